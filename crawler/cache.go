@@ -0,0 +1,149 @@
+package crawler
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Header of a saved response holding its redirect chain, removed once read back
+const cacheChainHeader = "X-Go-Crawler-Redirects"
+
+// Successful responses saved to a directory, one file per requested url named by
+// its sha256, in HTTP/1.1 wire format with the body decompressed
+type responseCache struct {
+	dir     string
+	ttl     time.Duration // age after which a saved response is fetched again, 0 for never
+	maxSize int64         // body bytes saved, one more than parsed so an oversized page is still noticed
+}
+
+// Body of a response read back from the cache, not from the network
+type cachedBody struct {
+	io.ReadCloser
+}
+
+func newResponseCache(dir string, ttl time.Duration, maxBodySize int64) *responseCache {
+	return &responseCache{dir: dir, ttl: ttl, maxSize: maxBodySize + 1}
+}
+
+func (self *responseCache) path(rawUrl string) string {
+	sum := sha256.Sum256([]byte(rawUrl))
+	return filepath.Join(self.dir, hex.EncodeToString(sum[:]))
+}
+
+// Saved response for the url, nil if there's none. Not fresh once it's older
+// than the ttl, it can then be revalidated with conditionalHeader
+func (self *responseCache) Get(rawUrl string) (resp *http.Response, fresh bool) {
+	path := self.path(rawUrl)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, false
+	}
+	fresh = self.ttl <= 0 || time.Since(info.ModTime()) <= self.ttl
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	resp, err = http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), nil)
+	if err != nil {
+		LogUrl(rawUrl).Warnf("Ignoring cached %s: %s", rawUrl, err)
+		return nil, false
+	}
+	// rebuild the requests so the final url and redirects are as when fetched
+	chain := strings.Fields(resp.Header.Get(cacheChainHeader))
+	resp.Header.Del(cacheChainHeader)
+	if len(chain) == 0 {
+		chain = []string{rawUrl}
+	}
+	var prev *http.Response
+	for _, hop := range chain {
+		hopUrl, err := url.Parse(hop)
+		if err != nil {
+			return nil, false
+		}
+		req := &http.Request{Method: http.MethodGet, URL: hopUrl, Header: make(http.Header), Response: prev}
+		prev = &http.Response{Request: req}
+	}
+	resp.Request = prev.Request
+	resp.Body = cachedBody{resp.Body}
+	return resp, fresh
+}
+
+// If-None-Match and If-Modified-Since from a saved response's ETag and
+// Last-Modified, so the server can answer 304 if it hasn't changed
+func conditionalHeader(saved *http.Response) http.Header {
+	header := make(http.Header)
+	if etag := saved.Header.Get("ETag"); len(etag) > 0 {
+		header.Set("If-None-Match", etag)
+	}
+	if modified := saved.Header.Get("Last-Modified"); len(modified) > 0 {
+		header.Set("If-Modified-Since", modified)
+	}
+	return header
+}
+
+// Mark a saved response fresh again after the server confirmed it with a 304
+func (self *responseCache) Touch(rawUrl string) {
+	now := time.Now()
+	if err := os.Chtimes(self.path(rawUrl), now, now); err != nil {
+		LogUrl(rawUrl).Warnf("Refreshing cached %s: %s", rawUrl, err)
+	}
+}
+
+// Save the response, returning it with its body read back from memory.
+// Failing to save is logged, the response is still usable
+func (self *responseCache) Put(rawUrl string, resp *http.Response) *http.Response {
+	data, err := io.ReadAll(io.LimitReader(resp.Body, self.maxSize))
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	if err != nil {
+		LogUrl(rawUrl).Warnf("Not caching %s: %s", rawUrl, err)
+		return resp
+	}
+
+	saved := *resp
+	saved.Header = resp.Header.Clone()
+	if chain := redirectChain(resp); len(chain) > 0 {
+		saved.Header.Set(cacheChainHeader, strings.Join(chain, " "))
+	}
+	saved.Body = io.NopCloser(bytes.NewReader(data))
+	saved.ContentLength = int64(len(data))
+	saved.TransferEncoding = nil
+	saved.Close = false
+	var b bytes.Buffer
+	if err := saved.Write(&b); err != nil {
+		LogUrl(rawUrl).Warnf("Not caching %s: %s", rawUrl, err)
+		return resp
+	}
+	if err := os.MkdirAll(self.dir, 0755); err != nil {
+		LogUrl(rawUrl).Warnf("Not caching %s: %s", rawUrl, err)
+		return resp
+	}
+	// through a temp file, so a crawl reading it concurrently never sees half of it
+	path := self.path(rawUrl)
+	tmp, err := os.CreateTemp(self.dir, filepath.Base(path)+".*.tmp")
+	if err == nil {
+		_, err = tmp.Write(b.Bytes())
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), path)
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}
+	if err != nil {
+		LogUrl(rawUrl).Warnf("Not caching %s: %s", rawUrl, err)
+	}
+	return resp
+}
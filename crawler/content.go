@@ -0,0 +1,96 @@
+package crawler
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Element matched by a simple selector: tag, #id, .class or tag#id, tag.class
+type contentSelector struct {
+	tag   string
+	id    string
+	class string
+}
+
+var selectorPattern = regexp.MustCompile(`^([a-z][a-z0-9]*)?(?:#([\w-]+)|\.([\w-]+))?$`)
+
+func parseContentSelector(selector string) (contentSelector, error) {
+	m := selectorPattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(selector)))
+	if m == nil || len(m[0]) == 0 {
+		return contentSelector{}, fmt.Errorf("content selector must be tag, #id, .class, tag#id or tag.class, got %q", selector)
+	}
+	return contentSelector{tag: m[1], id: m[2], class: m[3]}, nil
+}
+
+func (self contentSelector) Matches(token html.Token) bool {
+	if len(self.tag) > 0 && token.Data != self.tag {
+		return false
+	}
+	for _, attr := range token.Attr {
+		switch {
+		case len(self.id) > 0 && attr.Key == atom.Id.String():
+			return strings.ToLower(attr.Val) == self.id
+		case len(self.class) > 0 && attr.Key == atom.Class.String():
+			for _, class := range strings.Fields(strings.ToLower(attr.Val)) {
+				if class == self.class {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return len(self.id) == 0 && len(self.class) == 0
+}
+
+// Whether the tokenizer is inside <main>, <article> or an element matching the selectors
+type contentRegions struct {
+	selectors []contentSelector
+	open      []contentRegion // innermost last
+	seen      bool            // a region was found on the page
+}
+
+// Open region, nested counts elements of the same tag inside it so
+// only its own end tag closes it
+type contentRegion struct {
+	tag    string
+	nested int
+}
+
+func newContentRegions(selectors []contentSelector) *contentRegions {
+	selectors = append([]contentSelector{{tag: "main"}, {tag: "article"}}, selectors...)
+	return &contentRegions{selectors: selectors}
+}
+
+// Track a start or end tag, self-closing ones can't hold links
+func (self *contentRegions) Visit(token html.Token) {
+	switch token.Type {
+	case html.StartTagToken:
+		for _, selector := range self.selectors {
+			if selector.Matches(token) {
+				self.open = append(self.open, contentRegion{tag: token.Data})
+				self.seen = true
+				return
+			}
+		}
+		if len(self.open) > 0 && self.open[len(self.open)-1].tag == token.Data {
+			self.open[len(self.open)-1].nested++
+		}
+	case html.EndTagToken:
+		if len(self.open) == 0 || self.open[len(self.open)-1].tag != token.Data {
+			return
+		}
+		if top := &self.open[len(self.open)-1]; top.nested > 0 {
+			top.nested--
+		} else {
+			self.open = self.open[:len(self.open)-1]
+		}
+	}
+}
+
+func (self *contentRegions) Inside() bool {
+	return len(self.open) > 0
+}
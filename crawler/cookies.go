@@ -0,0 +1,76 @@
+package crawler
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Read cookies from a Netscape cookie file, as exported by browsers and curl:
+// domain, include subdomains, path, secure, expiry, name and value, tab separated
+func ReadCookieFile(path string) (cookies []*http.Cookie, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		httpOnly := strings.HasPrefix(line, "#HttpOnly_")
+		line = strings.TrimPrefix(line, "#HttpOnly_")
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("%s:%d: want 7 tab separated fields, got %d", path, n, len(fields))
+		}
+		cookie := &http.Cookie{
+			Domain:   fields[0],
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			Name:     fields[5],
+			Value:    fields[6],
+			HttpOnly: httpOnly,
+		}
+		// a leading dot marks cookies that are also sent to subdomains
+		cookie.Domain = strings.TrimPrefix(cookie.Domain, ".")
+		if strings.EqualFold(fields[1], "TRUE") {
+			cookie.Domain = "." + cookie.Domain
+		}
+		if expires, err := strconv.ParseInt(fields[4], 10, 64); err == nil && expires > 0 {
+			cookie.Expires = time.Unix(expires, 0)
+		}
+		cookies = append(cookies, cookie)
+	}
+	return cookies, scanner.Err()
+}
+
+// Jar holding the preloaded cookies, shared by every request of a crawl.
+// Cookies are set for their Domain, with a leading dot for its subdomains too
+func newCookieJar(cookies []*http.Cookie) http.CookieJar {
+	jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	for _, cookie := range cookies {
+		scheme := "http"
+		if cookie.Secure {
+			scheme = "https"
+		}
+		host := strings.TrimPrefix(cookie.Domain, ".")
+		if !strings.HasPrefix(cookie.Domain, ".") {
+			hostOnly := *cookie
+			hostOnly.Domain = "" // the jar sends cookies without a Domain to their host only
+			cookie = &hostOnly
+		}
+		jar.SetCookies(&url.URL{Scheme: scheme, Host: host, Path: cookie.Path}, []*http.Cookie{cookie})
+	}
+	return jar
+}
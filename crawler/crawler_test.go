@@ -0,0 +1,600 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Run the crawl, failing the test if it hasn't returned after a while
+func crawlWithin(t *testing.T, urls []string, opts Options) []Link {
+	t.Helper()
+	type crawled struct {
+		links []Link
+		err   error
+	}
+	done := make(chan crawled, 1)
+	go func() {
+		links, err := Crawl(urls, opts)
+		done <- crawled{links, err}
+	}()
+	select {
+	case res := <-done:
+		if res.err != nil {
+			t.Fatal(res.err)
+		}
+		return res.links
+	case <-time.After(10 * time.Second):
+		t.Fatal("crawl didn't return")
+		return nil
+	}
+}
+
+// Address nothing listens on
+func closedAddr(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	return addr
+}
+
+func TestCrawlFailingUrls(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`<html><a href="/broken">broken</a> <a href="/ok">ok</a></html>`))
+		case "/ok":
+			w.Write([]byte(`<html>ok</html>`))
+		default:
+			http.Error(w, "broken", http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	closed := "http://" + closedAddr(t) + "/"
+
+	links := crawlWithin(t, []string{server.URL + "/", closed}, Options{MaxDepth: 2, IgnoreRobots: true})
+	status := make(map[string]int)
+	failed := make(map[string]bool)
+	for _, link := range links {
+		status[link.Url] = link.Status
+		failed[link.Url] = len(link.Err) > 0
+	}
+	if len(links) != 4 {
+		t.Errorf("got %d links, want 4: %v", len(links), links)
+	}
+	if status[server.URL+"/broken"] != http.StatusInternalServerError || !failed[server.URL+"/broken"] {
+		t.Errorf("/broken: got status %d, want a failed 500", status[server.URL+"/broken"])
+	}
+	if !failed[closed] {
+		t.Errorf("%s: got no error", closed)
+	}
+	if status[server.URL+"/ok"] != http.StatusOK {
+		t.Errorf("/ok: got status %d, want 200", status[server.URL+"/ok"])
+	}
+}
+
+// Site on ip whose root links to pages that are slow to answer, recording the most
+// requests it had at once
+type busySite struct {
+	*httptest.Server
+	mu      sync.Mutex
+	current int
+	peak    int
+}
+
+func (self *busySite) enter() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.current++
+	self.peak = max(self.peak, self.current)
+}
+
+func (self *busySite) leave() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.current--
+}
+
+func newBusySite(t *testing.T, ip string, pages int) *busySite {
+	t.Helper()
+	listener, err := net.Listen("tcp", ip+":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	site := &busySite{}
+	site.Server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		site.enter()
+		defer site.leave()
+		if r.URL.Path != "/" {
+			time.Sleep(50 * time.Millisecond)
+			w.Write([]byte(`<html>page</html>`))
+			return
+		}
+		var links strings.Builder
+		for i := 0; i < pages; i++ {
+			fmt.Fprintf(&links, `<a href="/%d">%d</a>`, i, i)
+		}
+		w.Write([]byte("<html>" + links.String() + "</html>"))
+	}))
+	site.Listener.Close()
+	site.Listener = listener
+	site.Start()
+	return site
+}
+
+func TestCrawlPerHostConcurrency(t *testing.T) {
+	first := newBusySite(t, "127.0.0.1", 8)
+	defer first.Close()
+	second := newBusySite(t, "127.0.0.2", 8)
+	defer second.Close()
+
+	opts := Options{MaxDepth: 2, IgnoreRobots: true, Concurrency: 10, PerHostConcurrency: 2}
+	links := crawlWithin(t, []string{first.URL + "/", second.URL + "/"}, opts)
+	if len(links) != 18 {
+		t.Errorf("got %d links, want 18", len(links))
+	}
+	for _, site := range []*busySite{first, second} {
+		if site.peak > opts.PerHostConcurrency {
+			t.Errorf("%s: got %d requests at once, want at most %d", site.URL, site.peak, opts.PerHostConcurrency)
+		}
+	}
+}
+
+// Site of pages linking to each other, counting the requests for each path
+type countingSite struct {
+	*httptest.Server
+	mu   sync.Mutex
+	hits map[string]int
+}
+
+func newCountingSite(links map[string][]string) *countingSite {
+	site := unstartedCountingSite(links)
+	site.Start()
+	return site
+}
+
+func unstartedCountingSite(links map[string][]string) *countingSite {
+	site := &countingSite{hits: make(map[string]int)}
+	site.Server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		site.mu.Lock()
+		site.hits[r.URL.Path]++
+		site.mu.Unlock()
+		hrefs, ok := links[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		var page strings.Builder
+		for _, href := range hrefs {
+			fmt.Fprintf(&page, `<a href="%s">%s</a>`, href, href)
+		}
+		w.Write([]byte("<html>" + page.String() + "</html>"))
+	}))
+	return site
+}
+
+func TestCrawlDepthZero(t *testing.T) {
+	site := newCountingSite(map[string][]string{
+		"/":  {"/a", "/b"},
+		"/a": {"/c"},
+		"/b": {},
+	})
+	defer site.Close()
+
+	links := crawlWithin(t, []string{site.URL + "/"}, Options{MaxDepth: 0, IgnoreRobots: true})
+	if len(links) != 3 {
+		t.Fatalf("got %d links, want the seed and its 2 links: %v", len(links), links)
+	}
+	if links[0].Status != http.StatusOK {
+		t.Errorf("seed: got status %d, want it fetched", links[0].Status)
+	}
+	for _, link := range links[1:] {
+		if link.Depth != 1 || link.Status != 0 {
+			t.Errorf("%s: got depth %d and status %d, want it listed at depth 1 but not fetched", link.Url, link.Depth, link.Status)
+		}
+	}
+	if len(site.hits) != 1 || site.hits["/"] != 1 {
+		t.Errorf("got requests %v, want only the seed", site.hits)
+	}
+}
+
+func TestCrawlFetchesEachUrlOnce(t *testing.T) {
+	for _, strategy := range []string{"bfs", "dfs"} {
+		site := newCountingSite(map[string][]string{
+			"/":       {"/a", "/b", "/c", "/a#top"},
+			"/a":      {"/shared", "/b", "/"},
+			"/b":      {"/shared", "/c", "/a"},
+			"/c":      {"/shared", "/deep", "/"},
+			"/shared": {"/a", "/deep"},
+			"/deep":   {"/shared"},
+		})
+		crawlWithin(t, []string{site.URL + "/"}, Options{MaxDepth: 4, IgnoreRobots: true, Strategy: strategy})
+		site.Close()
+		if len(site.hits) != 6 {
+			t.Errorf("%s: got requests %v, want all 6 pages", strategy, site.hits)
+		}
+		for path, hits := range site.hits {
+			if hits != 1 {
+				t.Errorf("%s: %s requested %d times", strategy, path, hits)
+			}
+		}
+	}
+}
+
+func TestCrawlSameDomainIpv6(t *testing.T) {
+	listener, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("no ipv6 loopback: %s", err)
+	}
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	site := unstartedCountingSite(map[string][]string{
+		"/":  {"/a", "http://[0:0::1]:" + port + "/a", "http://[::2]:" + port + "/external"},
+		"/a": {"http://[::1]:" + port + "/"},
+	})
+	site.Listener.Close()
+	site.Listener = listener
+	site.Start()
+	defer site.Close()
+
+	seed := "http://[0::1]:" + port + "/"
+	links := crawlWithin(t, []string{seed}, Options{MaxDepth: 3, IgnoreRobots: true, SameDomain: true, Timeout: time.Second})
+	if len(links) != 3 {
+		t.Fatalf("got %d links, want the seed, /a and the external one: %v", len(links), links)
+	}
+	if links[1].External || links[1].Status != http.StatusOK {
+		t.Errorf("%s: got external %t and status %d, want it fetched on the seed host", links[1].Url, links[1].External, links[1].Status)
+	}
+	if !links[2].External || links[2].Status != 0 {
+		t.Errorf("%s: got external %t and status %d, want it recorded but not fetched", links[2].Url, links[2].External, links[2].Status)
+	}
+	if len(site.hits) != 2 || site.hits["/"] != 1 || site.hits["/a"] != 1 {
+		t.Errorf("got requests %v, want / and /a once", site.hits)
+	}
+}
+
+// Fetches waiting out a delay are running, so the idle timeout doesn't end the crawl
+func TestCrawlIdleTimeoutWaits(t *testing.T) {
+	for _, opts := range []Options{
+		{Delay: 250 * time.Millisecond, IdleTimeout: 100 * time.Millisecond},
+		{RequestsPerSecond: 5, IdleTimeout: 100 * time.Millisecond},
+	} {
+		site := newCountingSite(map[string][]string{
+			"/":  {"/a", "/b", "/c", "/d", "/e"},
+			"/a": {}, "/b": {}, "/c": {}, "/d": {}, "/e": {},
+		})
+		opts.MaxDepth = 2
+		opts.IgnoreRobots = true
+		links := crawlWithin(t, []string{site.URL + "/"}, opts)
+		site.Close()
+		fetched := 0
+		for _, link := range links {
+			if link.Status == http.StatusOK {
+				fetched++
+			}
+		}
+		if fetched != 6 {
+			t.Errorf("delay %s, %g requests per second: got %d of 6 pages fetched", opts.Delay, opts.RequestsPerSecond, fetched)
+		}
+	}
+}
+
+func TestCrawlRobotsOnlyBeforeFetching(t *testing.T) {
+	var mu sync.Mutex
+	hits := make(map[string]int) // map host and path to requests
+	var external string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.Host+r.URL.Path]++
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/robots.txt":
+			w.Write([]byte("User-agent: *\nDisallow: /private\n"))
+		case "/":
+			w.Write([]byte(`<html><a href="/a">a</a><a href="/private">private</a><a href="` + external + `">external</a></html>`))
+		default:
+			w.Write([]byte(`<html>page</html>`))
+		}
+	}))
+	defer server.Close()
+	// localhost is another host to the crawl, on the same server
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	external = "http://localhost:" + port + "/external"
+
+	links := crawlWithin(t, []string{server.URL + "/"}, Options{MaxDepth: 3, SameDomain: true})
+	byUrl := make(map[string]Link)
+	for _, link := range links {
+		byUrl[link.Url] = link
+	}
+	if len(links) != 4 {
+		t.Fatalf("got %d links, want the seed, /a, /private and the external one: %v", len(links), links)
+	}
+	if private := byUrl[server.URL+"/private"]; !private.Disallowed || private.Status != 0 {
+		t.Errorf("/private: got disallowed %t and status %d, want it listed as disallowed", private.Disallowed, private.Status)
+	}
+	if a := byUrl[server.URL+"/a"]; a.Disallowed || a.Status != http.StatusOK {
+		t.Errorf("/a: got disallowed %t and status %d, want it fetched", a.Disallowed, a.Status)
+	}
+	if link := byUrl[external]; !link.External || link.Disallowed {
+		t.Errorf("%s: got external %t and disallowed %t, want it listed as external", external, link.External, link.Disallowed)
+	}
+	host := server.Listener.Addr().String()
+	if hits[host+"/private"] != 0 || hits[host+"/robots.txt"] != 1 {
+		t.Errorf("got requests %v, want robots.txt once and not /private", hits)
+	}
+	if hits["localhost:"+port+"/robots.txt"] != 0 {
+		t.Error("robots.txt of a host that's never crawled was fetched")
+	}
+}
+
+// The delay spaces the requests sent, also when they waited for a slot held by a slow one
+func TestCrawlDelayBetweenRequests(t *testing.T) {
+	var mu sync.Mutex
+	var sent []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		sent = append(sent, time.Now())
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`<html><a href="/slow">slow</a><a href="/a">a</a><a href="/b">b</a><a href="/c">c</a></html>`))
+		case "/slow":
+			time.Sleep(500 * time.Millisecond)
+			fallthrough
+		default:
+			w.Write([]byte(`<html>page</html>`))
+		}
+	}))
+	defer server.Close()
+
+	delay := 200 * time.Millisecond
+	crawlWithin(t, []string{server.URL + "/"}, Options{MaxDepth: 2, IgnoreRobots: true, Concurrency: 1, Delay: delay})
+	if len(sent) != 5 {
+		t.Fatalf("got %d requests, want 5", len(sent))
+	}
+	for i := 1; i < len(sent); i++ {
+		// a little slack for the timer
+		if gap := sent[i].Sub(sent[i-1]); gap < delay-10*time.Millisecond {
+			t.Errorf("request %d sent %s after the one before, want at least %s", i, gap, delay)
+		}
+	}
+}
+
+// Links past the page cap are listed but not fetched
+func TestCrawlMaxPages(t *testing.T) {
+	for _, test := range []struct {
+		maxPages, links, fetched int
+	}{
+		{1, 4, 1}, // the seed and its links
+		{2, 5, 2}, // also /a's link
+	} {
+		site := newCountingSite(map[string][]string{
+			"/":  {"/a", "/b", "/c"},
+			"/a": {"/d"},
+			"/b": {}, "/c": {}, "/d": {},
+		})
+		links := crawlWithin(t, []string{site.URL + "/"}, Options{MaxDepth: 3, IgnoreRobots: true, MaxPages: test.maxPages})
+		site.Close()
+		fetched := 0
+		for _, link := range links {
+			if link.Status != 0 {
+				fetched++
+			}
+		}
+		if len(links) != test.links || fetched != test.fetched || len(site.hits) != test.fetched {
+			t.Errorf("max pages %d: got %d links, %d fetched and requests %v, want %d links, %d fetched",
+				test.maxPages, len(links), fetched, site.hits, test.links, test.fetched)
+		}
+	}
+}
+
+// Cancelling a bfs crawl leaves the links waiting for a slot unfetched, not failed
+func TestCrawlCancelledWaitingLinks(t *testing.T) {
+	site := newBusySite(t, "127.0.0.1", 40)
+	defer site.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	result, err := CrawlContext(ctx, []string{site.URL + "/"}, Options{MaxDepth: 2, IgnoreRobots: true, Concurrency: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %s after being cancelled at 200ms", elapsed)
+	}
+	if len(result.Failed) > 0 {
+		t.Errorf("got %d failed, want none: %v", len(result.Failed), result.Failed)
+	}
+	fetched := 0
+	for _, link := range result.Links {
+		if len(link.Err) > 0 {
+			t.Errorf("%s: got error %q", link.Url, link.Err)
+		}
+		if link.Status != 0 {
+			fetched++
+		}
+	}
+	if len(result.Links) != 41 || fetched == 0 || fetched >= 41 {
+		t.Errorf("got %d links with %d fetched, want 41 with some fetched", len(result.Links), fetched)
+	}
+}
+
+// MaxDuration also stops the bfs fetches launched but still waiting for a slot
+func TestCrawlMaxDurationBfs(t *testing.T) {
+	site := newBusySite(t, "127.0.0.1", 40)
+	defer site.Close()
+
+	start := time.Now()
+	opts := Options{MaxDepth: 2, IgnoreRobots: true, Concurrency: 2, MaxDuration: 300 * time.Millisecond}
+	links := crawlWithin(t, []string{site.URL + "/"}, opts)
+	// all 40 pages take a second at 2 at a time
+	if elapsed := time.Since(start); elapsed > 700*time.Millisecond {
+		t.Errorf("took %s with a %s budget", elapsed, opts.MaxDuration)
+	}
+	fetched := 0
+	for _, link := range links {
+		if link.Status != 0 {
+			fetched++
+		}
+		if len(link.Err) > 0 {
+			t.Errorf("%s: got error %q", link.Url, link.Err)
+		}
+	}
+	if len(links) != 41 || fetched >= 41 {
+		t.Errorf("got %d links with %d fetched, want 41 with some left unfetched", len(links), fetched)
+	}
+}
+
+// An interrupted crawl keeps the links it didn't get to in State, and picks them up again
+func TestCrawlStateResumesInterrupted(t *testing.T) {
+	site := newBusySite(t, "127.0.0.1", 40)
+	defer site.Close()
+	state := t.TempDir() + "/state.json"
+	opts := Options{MaxDepth: 2, IgnoreRobots: true, Concurrency: 2, State: state}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := CrawlContext(ctx, []string{site.URL + "/"}, opts); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := loadState(state)
+	if err != nil {
+		t.Fatalf("no state kept after being cancelled: %s", err)
+	}
+	if len(saved.Frontier) == 0 || len(saved.Frontier) >= 40 {
+		t.Errorf("got %d links to fetch saved, want some of 40", len(saved.Frontier))
+	}
+
+	links := crawlWithin(t, []string{site.URL + "/"}, opts)
+	if len(links) != 41 {
+		t.Errorf("got %d links, want 41", len(links))
+	}
+	for _, link := range links {
+		if link.Status != http.StatusOK {
+			t.Errorf("%s: got status %d after resuming, want 200", link.Url, link.Status)
+		}
+	}
+	if _, err := os.Stat(state); !os.IsNotExist(err) {
+		t.Errorf("state %s not removed once finished: %v", state, err)
+	}
+}
+
+// Streamed crawls only collect edges when asked to, and still count the pages they drop
+func TestCrawlOnLinkEdges(t *testing.T) {
+	site := newCountingSite(map[string][]string{"/": {"/a", "/b"}, "/a": {"/b"}, "/b": {"/"}})
+	defer site.Close()
+
+	for _, keepEdges := range []bool{false, true} {
+		streamed := 0
+		opts := Options{MaxDepth: 3, IgnoreRobots: true, KeepEdges: keepEdges, OnLink: func(Link) { streamed++ }}
+		result, err := CrawlContext(context.Background(), []string{site.URL + "/"}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if streamed != 3 || result.Stats.Fetched != 3 {
+			t.Errorf("keep edges %t: got %d links streamed and %d fetched, want 3", keepEdges, streamed, result.Stats.Fetched)
+		}
+		if want := map[bool]int{false: 0, true: 4}[keepEdges]; len(result.Edges) != want {
+			t.Errorf("keep edges %t: got %d edges, want %d", keepEdges, len(result.Edges), want)
+		}
+	}
+}
+
+// A dry run plans the seed's crawlable links even at the default depth of 1
+func TestCrawlDryRunDefaultDepth(t *testing.T) {
+	site := newCountingSite(map[string][]string{"/": {"/a", "/b", "mailto:me@example.com"}})
+	defer site.Close()
+
+	result, err := CrawlContext(context.Background(), []string{site.URL + "/"}, Options{IgnoreRobots: true, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var planned []string
+	for _, link := range result.Planned {
+		planned = append(planned, strings.TrimPrefix(link.Url, site.URL))
+	}
+	if strings.Join(planned, " ") != "/a /b" {
+		t.Errorf("got %v planned, want /a /b", planned)
+	}
+	if site.hits["/a"] > 0 || site.hits["/b"] > 0 {
+		t.Errorf("dry run fetched more than the seed: %v", site.hits)
+	}
+}
+
+// Shuffled links are fetched in the seed's order, not in whichever order they win the slots
+func TestCrawlShuffleSeedOrder(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		order = append(order, r.URL.Path)
+		mu.Unlock()
+		if r.URL.Path != "/" {
+			w.Write([]byte(`<html>page</html>`))
+			return
+		}
+		var links strings.Builder
+		for i := 0; i < 200; i++ {
+			fmt.Fprintf(&links, `<a href="/%d">%d</a>`, i, i)
+		}
+		w.Write([]byte("<html>" + links.String() + "</html>"))
+	}))
+	defer server.Close()
+
+	// enough links for some to be launched out of order if they raced for the slot
+	var orders []string
+	for run := 0; run < 2; run++ {
+		order = nil
+		crawlWithin(t, []string{server.URL + "/"}, Options{MaxDepth: 2, IgnoreRobots: true, Concurrency: 1, Shuffle: true, ShuffleSeed: 42})
+		orders = append(orders, strings.Join(order, " "))
+	}
+	if orders[0] != orders[1] {
+		t.Errorf("got orders %q and %q with one seed", orders[0], orders[1])
+	}
+	if strings.HasPrefix(orders[0], "/ /0 /1 /2 /3 ") {
+		t.Errorf("got the links in page order: %s", orders[0])
+	}
+}
+
+// MaxTotalBytes stops a bfs crawl's waiting fetches, and pages from the cache don't count toward it
+func TestCrawlMaxTotalBytes(t *testing.T) {
+	site := newBusySite(t, "127.0.0.1", 40)
+	defer site.Close()
+	cache := t.TempDir()
+
+	fetched := func(opts Options) int {
+		t.Helper()
+		n := 0
+		for _, link := range crawlWithin(t, []string{site.URL + "/"}, opts) {
+			if link.Status != 0 {
+				n++
+			}
+		}
+		return n
+	}
+	// the root alone is over 100 bytes
+	opts := Options{MaxDepth: 2, IgnoreRobots: true, Concurrency: 4, MaxTotalBytes: 100}
+	if n := fetched(opts); n != 1 {
+		t.Errorf("got %d fetched, want only the root", n)
+	}
+	opts.MaxTotalBytes = 0
+	opts.CacheDir = cache
+	if n := fetched(opts); n != 41 {
+		t.Errorf("got %d fetched filling the cache, want 41", n)
+	}
+	opts.MaxTotalBytes = 100
+	if n := fetched(opts); n != 41 {
+		t.Errorf("got %d fetched from the cache, want 41", n)
+	}
+}
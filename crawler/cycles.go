@@ -0,0 +1,76 @@
+package crawler
+
+import "sort"
+
+// Groups of pages that link to each other in cycles, each url reachable from every
+// other one of its group by following links. Smallest groups first, those are the
+// tight cycles worth a look, a site's navigation usually makes one big group.
+// Urls are compared without their #fragment, pages only linking to themselves are left out
+func Cycles(result Result) [][]string {
+	links := make(map[string][]string) // map page url to the urls it links to
+	var pages []string                 // in the order first found, so the output is stable
+	seen := make(map[string]bool)
+	add := func(url string) {
+		if !seen[url] {
+			seen[url] = true
+			pages = append(pages, url)
+		}
+	}
+	for _, edge := range result.Edges {
+		from, to := stripFragment(edge.From), stripFragment(edge.To)
+		if from == to {
+			continue
+		}
+		add(from)
+		add(to)
+		links[from] = append(links[from], to)
+	}
+
+	// Tarjan's strongly connected components
+	index := make(map[string]int)  // map url to the order it was visited in
+	lowest := make(map[string]int) // map url to the lowest index reachable from it
+	onStack := make(map[string]bool)
+	var stack []string
+	var cycles [][]string
+	var visit func(url string)
+	visit = func(url string) {
+		index[url] = len(index)
+		lowest[url] = index[url]
+		stack = append(stack, url)
+		onStack[url] = true
+		for _, to := range links[url] {
+			if _, visited := index[to]; !visited {
+				visit(to)
+				lowest[url] = min(lowest[url], lowest[to])
+			} else if onStack[to] {
+				lowest[url] = min(lowest[url], index[to])
+			}
+		}
+		if lowest[url] != index[url] {
+			return
+		}
+		var group []string
+		for {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[top] = false
+			group = append(group, top)
+			if top == url {
+				break
+			}
+		}
+		if len(group) > 1 {
+			sort.Strings(group)
+			cycles = append(cycles, group)
+		}
+	}
+	for _, url := range pages {
+		if _, visited := index[url]; !visited {
+			visit(url)
+		}
+	}
+	sort.SliceStable(cycles, func(i, j int) bool {
+		return len(cycles[i]) < len(cycles[j])
+	})
+	return cycles
+}
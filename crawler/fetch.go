@@ -0,0 +1,499 @@
+package crawler
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// API-specific Errors
+type HttpGetError struct {
+	original    string
+	status      int
+	contentType string
+}
+
+func (self HttpGetError) Error() string {
+	return self.original
+}
+
+// Whether a Content-Type is worth tokenizing, a missing one is tried as html
+func isHtml(contentType string) bool {
+	if len(contentType) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+// Requests of a crawl, sharing one client
+type fetcher struct {
+	client    *http.Client
+	retries   int
+	userAgent string
+	language  string
+	header    http.Header
+	basicAuth string
+	cache     *responseCache // nil for none
+	okStatus  []statusRange  // statuses that aren't errors, nil for 2xx
+}
+
+func newFetcher(opts Options) *fetcher {
+	okStatus, _ := parseStatusRanges(opts.OkStatus) // checked by Validate
+	var cache *responseCache
+	if len(opts.CacheDir) > 0 {
+		cache = newResponseCache(opts.CacheDir, opts.CacheTTL, opts.MaxBodySize)
+	}
+	return &fetcher{
+		client:    newClient(opts),
+		retries:   opts.Retries,
+		userAgent: opts.UserAgent,
+		language:  opts.AcceptLanguage,
+		header:    opts.Header,
+		basicAuth: opts.BasicAuth,
+		cache:     cache,
+		okStatus:  okStatus,
+	}
+}
+
+// Copy of opts.Client if given, so the caller's isn't modified, else a client
+// through opts.Proxy. Cookies set by responses are sent on later requests
+func newClient(opts Options) *http.Client {
+	if opts.Client != nil {
+		client := *opts.Client
+		if client.Jar == nil {
+			client.Jar = newCookieJar(opts.Cookies)
+		}
+		if client.Timeout == 0 {
+			client.Timeout = opts.Timeout
+		}
+		if client.CheckRedirect == nil {
+			client.CheckRedirect = checkRedirect
+		}
+		return &client
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second} // as the default transport's
+	if opts.DialTimeout > 0 {
+		dialer.Timeout = opts.DialTimeout
+		transport.DialContext = dialer.DialContext
+		transport.TLSHandshakeTimeout = opts.DialTimeout
+	}
+	transport.ResponseHeaderTimeout = opts.HeaderTimeout
+	// proxy credentials in the url are sent as Proxy-Authorization,
+	// https targets are tunnelled with CONNECT
+	transport.Proxy = http.ProxyFromEnvironment
+	if len(opts.Proxy) > 0 {
+		if proxyUrl, err := url.Parse(opts.Proxy); err == nil && isSocksProxy(proxyUrl) {
+			// hostnames are passed to the proxy unresolved, so it does the DNS lookup
+			if dialer, err := proxy.FromURL(proxyUrl, dialer); err == nil {
+				transport.Proxy = nil
+				transport.DialContext = dialer.(proxy.ContextDialer).DialContext
+			}
+		} else if err == nil {
+			transport.Proxy = http.ProxyURL(proxyUrl)
+		}
+	}
+	if len(opts.Resolve) > 0 {
+		transport.DialContext = resolvingDial(transport.DialContext, opts.Resolve)
+	}
+	var roundTripper http.RoundTripper = transport
+	if len(opts.InsecureHosts) > 0 {
+		roundTripper = newInsecureRouter(transport, opts.InsecureHosts)
+	}
+	return &http.Client{
+		Transport:     roundTripper,
+		Jar:           newCookieJar(opts.Cookies),
+		Timeout:       opts.Timeout,
+		CheckRedirect: checkRedirect,
+	}
+}
+
+// Dial the ip given for an address' host instead of looking it up, other hosts through dial
+func resolvingDial(dial func(ctx context.Context, network, addr string) (net.Conn, error),
+	resolve map[string]string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	ips := make(map[string]string)
+	for host, ip := range resolve {
+		ips[strings.ToLower(host)] = ip
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if host, port, err := net.SplitHostPort(addr); err == nil {
+			if ip, ok := ips[strings.ToLower(host)]; ok {
+				Log.Debugf("Resolving %s to %s", host, ip)
+				addr = net.JoinHostPort(ip, port)
+			}
+		}
+		return dial(ctx, network, addr)
+	}
+}
+
+// Whether connections go through a SOCKS5 proxy, socks5h is the same as
+// the hostname is always resolved by the proxy
+func isSocksProxy(proxyUrl *url.URL) bool {
+	return proxyUrl.Scheme == "socks5" || proxyUrl.Scheme == "socks5h"
+}
+
+// Sends requests to the insecure hosts ("*" for all) through a transport that
+// skips certificate verification, others through the verifying one
+type insecureRouter struct {
+	insecure map[string]bool
+	verified http.RoundTripper
+	skipped  http.RoundTripper
+}
+
+func newInsecureRouter(transport *http.Transport, hosts []string) *insecureRouter {
+	skipped := transport.Clone()
+	skipped.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	self := &insecureRouter{insecure: make(map[string]bool), verified: transport, skipped: skipped}
+	for _, host := range hosts {
+		self.insecure[strings.ToLower(host)] = true
+	}
+	return self
+}
+
+func (self *insecureRouter) RoundTrip(req *http.Request) (*http.Response, error) {
+	if self.insecure["*"] || self.insecure[strings.ToLower(req.URL.Hostname())] {
+		return self.skipped.RoundTrip(req)
+	}
+	return self.verified.RoundTrip(req)
+}
+
+// Returned, wrapped, for a redirect back to a url already on the chain
+var ErrRedirectLoop = errors.New("redirect loop")
+
+// Log each redirect hop, stopping at a loop or after 10 like the default policy
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	for _, prev := range via {
+		if prev.URL.String() == req.URL.String() {
+			var chain []string
+			for _, hop := range via {
+				chain = append(chain, hop.URL.String())
+			}
+			chain = append(chain, req.URL.String())
+			return fmt.Errorf("%w: %s", ErrRedirectLoop, strings.Join(chain, " -> "))
+		}
+	}
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	LogUrl(req.URL.String()).Debugf("Redirect %s -> %s", via[len(via)-1].URL, req.URL)
+	return nil
+}
+
+// Urls redirected through to reach resp, from the requested url to the final one.
+// Empty if the response was not redirected
+func redirectChain(resp *http.Response) (chain []string) {
+	req := resp.Request
+	for req != nil && req.Response != nil {
+		chain = append([]string{req.URL.String()}, chain...)
+		req = req.Response.Request
+	}
+	if len(chain) > 0 && req != nil {
+		chain = append([]string{req.URL.String()}, chain...)
+	}
+	return
+}
+
+// Body that releases the request's context once closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (self cancelBody) Close() error {
+	defer self.cancel()
+	return self.ReadCloser.Close()
+}
+
+// Inclusive range of status codes
+type statusRange struct {
+	from, to int
+}
+
+// Parse a comma separated list of codes and ranges, e.g. 200-299,304
+func parseStatusRanges(value string) ([]statusRange, error) {
+	var ranges []statusRange
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if len(part) == 0 {
+			continue
+		}
+		from, to, isRange := strings.Cut(part, "-")
+		if !isRange {
+			to = from
+		}
+		first, err1 := strconv.Atoi(strings.TrimSpace(from))
+		last, err2 := strconv.Atoi(strings.TrimSpace(to))
+		if err1 != nil || err2 != nil || first < 100 || last > 999 || first > last {
+			return nil, fmt.Errorf("ok status must be codes or ranges like 200-299,304, got %q", part)
+		}
+		ranges = append(ranges, statusRange{first, last})
+	}
+	return ranges, nil
+}
+
+// Whether a response with the status is a successful fetch, 2xx by default
+func (self *fetcher) ok(status int) bool {
+	if len(self.okStatus) == 0 {
+		return status >= 200 && status <= 299
+	}
+	for _, ok := range self.okStatus {
+		if status >= ok.from && status <= ok.to {
+			return true
+		}
+	}
+	return false
+}
+
+// Caller must close resp.Body. Connection errors, 429 and 5xx are retried
+// with exponential backoff, other errors fail fast. With a cache successful
+// responses are saved, and served from it while fresh. Stale ones are requested
+// conditionally and reused if the server answers 304 Not Modified
+func (self *fetcher) getUrl(ctx context.Context, url string) (resp *http.Response, err error) {
+	var saved *http.Response // stale cached response, revalidated
+	var conditional http.Header
+	if self.cache != nil {
+		cached, fresh := self.cache.Get(url)
+		if cached != nil && fresh {
+			LogUrl(url).Debugf("From cache: %s", url)
+			return cached, nil
+		}
+		if cached != nil {
+			saved, conditional = cached, conditionalHeader(cached)
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		resp, err = self.getOnce(ctx, url, conditional)
+		if err == nil && saved != nil && resp.StatusCode == http.StatusNotModified {
+			resp.Body.Close()
+			LogUrl(url).Debugf("Not modified, from cache: %s", url)
+			self.cache.Touch(url)
+			return saved, nil
+		}
+		if err == nil && self.ok(resp.StatusCode) {
+			if self.cache != nil {
+				resp = self.cache.Put(url, resp)
+			}
+			return
+		}
+
+		wait, retry := retryDelay(resp, err, attempt)
+		if err == nil {
+			resp.Body.Close()
+			errStr := fmt.Sprintf("Error (%d): %s", resp.StatusCode, url)
+			err = HttpGetError{
+				original:    errStr,
+				status:      resp.StatusCode,
+				contentType: resp.Header.Get("Content-Type"),
+			}
+			LogUrl(url).Status(resp.StatusCode).Debugf("%s", err)
+		}
+		if !retry || attempt >= self.retries || ctx.Err() != nil {
+			return nil, err
+		}
+
+		LogUrl(url).Debugf("Retrying %s in %s (%d/%d)", url, wait, attempt+1, self.retries)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		}
+	}
+}
+
+// Single request, times out after client.Timeout, with the extra headers given before the custom ones
+func (self *fetcher) getOnce(ctx context.Context, url string, extra http.Header) (resp *http.Response, err error) {
+	LogUrl(url).Debugf("Downloading %s", url)
+	var cancel context.CancelFunc
+	if self.client.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, self.client.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		cancel()
+		LogUrl(url).Debugf("Error: %s", err)
+		return
+	}
+	self.setHeader(req, extra)
+	resp, err = self.client.Do(req)
+	if err != nil {
+		cancel()
+		if errors.Is(err, ErrRedirectLoop) {
+			err = errors.Unwrap(err) // drop the *url.Error, its url is the last Location and the chain says more
+		}
+		LogUrl(url).Debugf("Error: %s", err)
+		return
+	}
+	resp.Body = cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	if err = decodeBody(resp); err != nil {
+		resp.Body.Close()
+		LogUrl(url).Debugf("Error: %s", err)
+		return nil, err
+	}
+	return
+}
+
+// Headers sent with every request: User-Agent, Accept-Language, the extra ones,
+// then Options.Header replacing any of them, and basic auth
+func (self *fetcher) setHeader(req *http.Request, extra http.Header) {
+	req.Header.Set("User-Agent", self.userAgent)
+	if len(self.language) > 0 {
+		req.Header.Set("Accept-Language", self.language)
+	}
+	for name, values := range extra {
+		req.Header[name] = values
+	}
+	for name, values := range self.header {
+		req.Header.Del(name)
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	if len(self.basicAuth) > 0 {
+		user, pass, _ := strings.Cut(self.basicAuth, ":")
+		req.SetBasicAuth(user, pass)
+	}
+	// set explicitly so it survives custom headers, the transport then
+	// leaves decompression to decodeBody
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+}
+
+// Body reading through a decompressor, closing both
+type decodedBody struct {
+	io.Reader
+	decoder io.Closer
+	body    io.ReadCloser
+}
+
+func (self decodedBody) Close() error {
+	self.decoder.Close()
+	return self.body.Close()
+}
+
+// Body cut off after a number of bytes, logging when there was more
+type limitedBody struct {
+	io.ReadCloser
+	limit   int64
+	read    int64
+	total   *atomic.Int64 // also counts the bytes read, shared by the bodies of a crawl
+	url     string
+	checked bool // whether reading past the limit was tried
+}
+
+func (self *limitedBody) Read(p []byte) (int, error) {
+	if self.read >= self.limit {
+		if !self.checked {
+			self.checked = true
+			var next [1]byte
+			if n, _ := self.ReadCloser.Read(next[:]); n > 0 {
+				LogUrl(self.url).Warnf("Body too large, parsing the first %d bytes: %s", self.limit, self.url)
+			}
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > self.limit-self.read {
+		p = p[:self.limit-self.read]
+	}
+	n, err := self.ReadCloser.Read(p)
+	self.read += int64(n)
+	if self.total != nil {
+		self.total.Add(int64(n))
+	}
+	return n, err
+}
+
+// Transparently decompress gzip and deflate encoded bodies
+func decodeBody(resp *http.Response) error {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	var decoder io.ReadCloser
+	switch encoding {
+	case "gzip", "x-gzip":
+		reader, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return err
+		}
+		decoder = reader
+	case "deflate":
+		// meant to be zlib wrapped, but some servers send raw deflate
+		buffered := bufio.NewReader(resp.Body)
+		header, _ := buffered.Peek(2)
+		if len(header) == 2 && header[0]&0x0f == 8 && (int(header[0])<<8|int(header[1]))%31 == 0 {
+			reader, err := zlib.NewReader(buffered)
+			if err != nil {
+				return err
+			}
+			decoder = reader
+		} else {
+			decoder = flate.NewReader(buffered)
+		}
+	default:
+		return nil
+	}
+	resp.Body = decodedBody{Reader: decoder, decoder: decoder, body: resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return nil
+}
+
+// Whether a failed attempt is worth retrying and how long to wait first.
+// Retry-After is used when given, else 500ms doubled per attempt plus jitter
+func retryDelay(resp *http.Response, err error, attempt int) (time.Duration, bool) {
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return 0, false
+		}
+		// only network errors, bad urls or redirect policy errors won't get better
+		cause := err
+		if urlErr, ok := err.(*url.Error); ok {
+			cause = urlErr.Err
+		}
+		var netErr net.Error
+		if !errors.As(cause, &netErr) && !errors.Is(cause, io.EOF) && !errors.Is(cause, io.ErrUnexpectedEOF) {
+			return 0, false
+		}
+	} else if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+		return 0, false
+	}
+
+	base := 500 * time.Millisecond << uint(attempt)
+	wait := base + time.Duration(rand.Int63n(int64(base)))
+	if resp != nil {
+		if after := resp.Header.Get("Retry-After"); len(after) > 0 {
+			if secs, err := strconv.Atoi(after); err == nil {
+				wait = time.Duration(secs) * time.Second
+			} else if date, err := http.ParseTime(after); err == nil {
+				wait = time.Until(date)
+			}
+		}
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait, true
+}
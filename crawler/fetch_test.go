@@ -0,0 +1,53 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetUrlHttpErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			http.NotFound(w, r)
+		case "/broken":
+			http.Error(w, "broken", http.StatusInternalServerError)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer server.Close()
+	fetch := newFetcher(Options{Client: server.Client()}.withDefaults())
+
+	for _, test := range []struct {
+		path   string
+		status int
+	}{
+		{"/missing", http.StatusNotFound},
+		{"/broken", http.StatusInternalServerError},
+	} {
+		resp, err := fetch.getUrl(context.Background(), server.URL+test.path)
+		if err == nil {
+			resp.Body.Close()
+			t.Errorf("%s: got no error", test.path)
+			continue
+		}
+		httpErr, ok := err.(HttpGetError)
+		if !ok {
+			t.Errorf("%s: got %T, want HttpGetError", test.path, err)
+		} else if httpErr.status != test.status {
+			t.Errorf("%s: got status %d, want %d", test.path, httpErr.status, test.status)
+		}
+		if resp != nil {
+			t.Errorf("%s: got a response with the error", test.path)
+		}
+	}
+
+	resp, err := fetch.getUrl(context.Background(), server.URL+"/")
+	if err != nil {
+		t.Fatalf("/: %s", err)
+	}
+	resp.Body.Close()
+}
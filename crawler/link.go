@@ -0,0 +1,524 @@
+package crawler
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+)
+
+type Link struct {
+	Url          string `json:"url"`
+	Text         string `json:"text"`                    // tag where href was found
+	Element      string `json:"element,omitempty"`       // tag the url came from, e.g. a or img, header for Link headers, empty for seeds
+	Nofollow     bool   `json:"nofollow,omitempty"`      // anchor has rel="nofollow"
+	LastModified string `json:"last_modified,omitempty"` // Last-Modified header of the response
+	Depth        int    `json:"depth"`
+	External     bool   `json:"external"`            // host outside the seed hosts, recorded but not crawled past ExternalDepth
+	Title        string `json:"title"`               // <title> of the linked page, if fetched
+	FinalUrl     string `json:"final_url,omitempty"` // where url redirected to, empty if not redirected
+	Parent       string `json:"parent,omitempty"`    // url of the page the link was found on, empty for seeds
+	Status       int    `json:"status,omitempty"`    // fetch outcome, zero values if never fetched
+	ContentType  string `json:"content_type,omitempty"`
+	Err          string `json:"error,omitempty"`
+	Canonical    string `json:"canonical,omitempty"`    // <link rel="canonical"> of the linked page, if fetched
+	MetaRefresh  string `json:"meta_refresh,omitempty"` // url the linked page redirects to with <meta http-equiv="refresh">
+	Scheme       string `json:"scheme,omitempty"`       // of a url that's a leaf, never fetched: mailto, tel, ftp, data...
+	// levels off the seed hosts of an External link, 1 if found on a seed host's page
+	OffsiteDepth int  `json:"offsite_depth,omitempty"`
+	MixedContent bool `json:"mixed_content,omitempty"` // http url found on an https page
+	// each hop from Url to FinalUrl, both included, empty if not redirected
+	Redirects  []string `json:"redirects,omitempty"`
+	Disallowed bool     `json:"disallowed,omitempty"` // by robots.txt, recorded but not fetched
+}
+
+// Anchor that isn't in a page's links, and why
+type rejectedLink struct {
+	href   string // resolved url, else the raw href
+	text   string
+	reason string
+}
+
+// Page -> link found on the page
+type Edge struct {
+	From string
+	To   string
+}
+
+// Url whose fetch failed
+type Failure struct {
+	Url    string
+	Depth  int
+	Status int // zero if no response, e.g. connection errors
+	Err    string
+}
+
+// Everything gathered by a crawl
+type Result struct {
+	Links   []Link    // unique links in visiting order
+	Edges   []Edge    // every link found on a fetched page, including already visited ones
+	Failed  []Failure // fetches that errored, in the order they failed
+	Planned []Link    // with Options.DryRun, links past the seeds that would have been fetched
+	Stats   Stats
+}
+
+// Totals of a crawl
+type Stats struct {
+	Discovered int           // unique urls found
+	Fetched    int           // urls requested, including failed ones
+	Hosts      int           // unique hosts of the urls found
+	Statuses   map[int]int   // map status code to count of fetches, 0 for no response
+	Bytes      int64         // body bytes read, decompressed
+	Elapsed    time.Duration // wall time of the crawl
+}
+
+// Result of fetching and parsing a page
+type pageResult struct {
+	url          string   // requested url
+	depth        int      // depth of the requested url
+	offsiteDepth int      // levels off the seed hosts of the requested url
+	finalUrl     string   // url after redirects, empty if not redirected
+	redirects    []string // each hop from url to finalUrl
+	status       int
+	contentType  string
+	lastModified string
+	err          string // fetch error, empty on success
+	disallowed   bool   // by robots.txt, so not fetched
+	bytes        int64  // body bytes read
+	title        string
+	canonical    string         // <link rel="canonical"> href, resolved
+	rejected     []rejectedLink // anchors dropped while extracting
+	metaRefresh  string         // <meta http-equiv="refresh"> url, resolved
+	links        []Link
+}
+
+func (self Link) String() string {
+	spacer := strings.Repeat("\t", self.Depth)
+	return fmt.Sprintf("%s%s (%d) - %s", spacer, self.Text, self.Depth, self.Url)
+}
+
+// Link with the outcome of fetching it
+func (self Link) withPage(page pageResult) Link {
+	self.Title = page.title
+	self.FinalUrl = page.finalUrl
+	self.Redirects = page.redirects
+	self.Status = page.status
+	self.ContentType = page.contentType
+	self.LastModified = page.lastModified
+	self.Err = page.err
+	self.Canonical = page.canonical
+	self.MetaRefresh = page.metaRefresh
+	return self
+}
+
+func (self Link) Valid() bool {
+	return len(self.invalidReason()) == 0
+}
+
+// Why Valid rejects the link, "" if it doesn't
+func (self Link) invalidReason() string {
+	switch {
+	case len(self.Url) == 0:
+		return "no url, the href is missing, only a #fragment or can't be parsed"
+	case strings.Contains(strings.ToLower(self.Url), "javascript"):
+		return "javascript url"
+	case len(self.Text) == 0:
+		return "empty text"
+	}
+	return ""
+}
+
+// Attribute holding the url of each asset element type
+var assetAttrs = map[atom.Atom]atom.Atom{
+	atom.Img:    atom.Src,
+	atom.Link:   atom.Href, // only rel=stylesheet
+	atom.Script: atom.Src,
+	atom.Iframe: atom.Src,
+}
+
+// Whether name is an element type ExtractLinks can collect besides anchors
+func IsAssetType(name string) bool {
+	_, ok := assetAttrs[atom.Lookup([]byte(name))]
+	return ok
+}
+
+// Extract anchors from a response body, hrefs are resolved against the
+// page's <base href>, else the effective url of the response (after redirects). Urls of the asset
+// element types given (img, link, script, iframe) are collected too
+func ExtractLinks(resp *http.Response, depth int, assetTypes ...string) (links []Link) {
+	return extractPage(resp, depth, assetTypes, nil).links
+}
+
+// Extract the first <title>, <link rel="canonical">, <meta> refresh, anchors and assets from a response body,
+// links' parent is the response url. With content only anchors inside its regions are kept,
+// unless the page has none
+func extractPage(resp *http.Response, depth int, assetTypes []string, content *contentRegions) (result pageResult) {
+	body := decodeCharset(resp.Body, resp.Header.Get("Content-Type"))
+	page := html.NewTokenizer(body) // tokenizer parse html into tokens
+	var base *url.URL
+	secure := false // links to http urls are mixed content, whatever the <base>
+	if resp.Request != nil {
+		base = resp.Request.URL
+		result.url = base.String()
+		secure = base.Scheme == "https"
+	}
+
+	assets := make(map[atom.Atom]bool)
+	for _, name := range assetTypes {
+		assets[atom.Lookup([]byte(name))] = true
+	}
+
+	var start *html.Token
+	var text string
+	var alt string // alt of images inside the anchor, used when it has no text
+	inTitle, hasTitle := false, false
+	hasBase := false
+	var outside []int // positions in result.links of anchors outside the content regions
+	// add the open anchor with the text since its start
+	endAnchor := func() {
+		if len(strings.TrimSpace(text)) == 0 {
+			text = alt
+		}
+		link := NewLink(*start, text, depth, base)
+		link.Parent = result.url
+		if link.Valid() {
+			if content != nil && !content.Inside() {
+				LogLink(link.Url, link.Depth).Debugf("Outside content %v", link)
+				outside = append(outside, len(result.links))
+			}
+			result.links = append(result.links, link)
+			LogLink(link.Url, link.Depth).Debugf("Link Found %v", link)
+		} else {
+			href := link.Url
+			for _, attr := range start.Attr {
+				if attr.Key == atom.Href.String() && len(href) == 0 {
+					href = attr.Val
+				}
+			}
+			result.rejected = append(result.rejected, rejectedLink{href, link.Text, link.invalidReason()})
+		}
+		start = nil
+		text, alt = "", ""
+	}
+
+	for {
+		_ = page.Next()       // move tokenizer forward
+		token := page.Token() // get token
+
+		// malformed markup is tokenized as best it can, only the end of the body
+		// or failing to read it stops the tokenizer
+		if token.Type == html.ErrorToken {
+			if err := page.Err(); err != io.EOF {
+				LogUrl(result.url).Warnf("Stopped parsing %s: %s", result.url, err)
+			}
+			if start != nil {
+				endAnchor() // never closed
+			}
+			if secure {
+				for i := range result.links {
+					result.links[i].MixedContent = strings.HasPrefix(strings.ToLower(result.links[i].Url), "http:")
+				}
+			}
+			if content != nil && content.seen {
+				for _, i := range outside {
+					link := result.links[i]
+					result.rejected = append(result.rejected, rejectedLink{link.Url, link.Text, "outside the content regions"})
+				}
+				result.links = dropLinks(result.links, outside)
+			}
+			return
+		}
+		if content != nil {
+			content.Visit(token)
+		}
+
+		// Set text for previous token if have start, including text of nested tags
+		if start != nil && token.Type == html.TextToken {
+			text = fmt.Sprintf("%s%s", text, token.Data)
+		}
+		if start != nil && token.DataAtom == atom.Img &&
+			(token.Type == html.StartTagToken || token.Type == html.SelfClosingTagToken) {
+			for _, attr := range token.Attr {
+				if attr.Key == atom.Alt.String() {
+					alt = strings.TrimSpace(fmt.Sprintf("%s %s", alt, attr.Val))
+				}
+			}
+		}
+
+		// The first <base href> replaces the response url for resolving later links
+		if token.DataAtom == atom.Base && !hasBase &&
+			(token.Type == html.StartTagToken || token.Type == html.SelfClosingTagToken) {
+			for _, attr := range token.Attr {
+				if attr.Key != atom.Href.String() {
+					continue
+				}
+				hasBase = true
+				if href, err := url.Parse(strings.TrimSpace(attr.Val)); err == nil {
+					if base != nil {
+						href = base.ResolveReference(href)
+					}
+					base = href
+					LogUrl(result.url).Debugf("Base %s", base)
+				}
+			}
+		}
+
+		// Keep only the first title
+		if token.DataAtom == atom.Title && !hasTitle {
+			switch token.Type {
+			case html.StartTagToken:
+				inTitle = true
+			case html.EndTagToken:
+				inTitle, hasTitle = false, true
+				result.title = strings.TrimSpace(strings.Replace(result.title, "\n", " ", -1))
+			}
+		}
+		if inTitle && token.Type == html.TextToken {
+			result.title += token.Data
+		}
+
+		if token.DataAtom == atom.Link && len(result.canonical) == 0 && relSet(token)["canonical"] &&
+			(token.Type == html.StartTagToken || token.Type == html.SelfClosingTagToken) {
+			for _, attr := range token.Attr {
+				if attr.Key == atom.Href.String() {
+					result.canonical = resolveUrl(base, strings.TrimSpace(attr.Val))
+				}
+			}
+		}
+
+		if token.DataAtom == atom.Meta && len(result.metaRefresh) == 0 &&
+			(token.Type == html.StartTagToken || token.Type == html.SelfClosingTagToken) {
+			var equiv, content string
+			for _, attr := range token.Attr {
+				switch attr.Key {
+				case atom.HttpEquiv.String():
+					equiv = strings.ToLower(strings.TrimSpace(attr.Val))
+				case atom.Content.String():
+					content = attr.Val
+				}
+			}
+			if refresh := refreshUrl(content); equiv == "refresh" && len(refresh) > 0 {
+				result.metaRefresh = resolveUrl(base, refresh)
+			}
+		}
+
+		if assets[token.DataAtom] &&
+			(token.Type == html.StartTagToken || token.Type == html.SelfClosingTagToken) {
+			if link, ok := NewAsset(token, depth, base); ok {
+				link.Parent = result.url
+				result.links = append(result.links, link)
+				LogLink(link.Url, link.Depth).Debugf("Asset Found %v", link)
+			}
+		}
+
+		// Set start if anchor token
+		if token.DataAtom == atom.A {
+			switch token.Type {
+			case html.StartTagToken:
+				if start != nil {
+					endAnchor() // anchors don't nest, a new one closes the open one
+				}
+				if len(token.Attr) > 0 {
+					start = &token
+				}
+			case html.EndTagToken:
+				if start == nil {
+					LogUrl(result.url).Warnf("Link End found, no Start: %s", text)
+					text, alt = "", ""
+					continue
+				}
+				endAnchor()
+			}
+		}
+	}
+}
+
+// rel="next" and rel="prev" urls of the response's Link headers, e.g. pages of an api,
+// resolved against the response url
+func headerLinks(resp *http.Response, depth int) (links []Link) {
+	var base *url.URL
+	if resp.Request != nil {
+		base = resp.Request.URL
+	}
+	for _, header := range resp.Header.Values("Link") {
+		for len(header) > 0 {
+			start, end := strings.Index(header, "<"), strings.Index(header, ">")
+			if start < 0 || end < start {
+				break
+			}
+			href := header[start+1 : end]
+			header = header[end+1:]
+			params := header
+			if next := strings.Index(header, "<"); next >= 0 {
+				params, header = header[:next], header[next:]
+			} else {
+				header = ""
+			}
+			for _, param := range strings.Split(params, ";") {
+				key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if !strings.EqualFold(key, "rel") {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.ToLower(strings.Trim(value, `",`))) {
+					if rel != "next" && rel != "prev" {
+						continue
+					}
+					link := Link{Url: resolveUrl(base, strings.TrimSpace(href)), Text: rel, Depth: depth, Element: "header"}
+					if link.Valid() {
+						links = append(links, link)
+					}
+				}
+			}
+		}
+	}
+	return
+}
+
+// Url of a refresh <meta>'s content, e.g. 0;url=/new or 5; URL='/new',
+// "" if it only reloads the page
+func refreshUrl(content string) string {
+	i := strings.IndexAny(content, ";,")
+	if i < 0 {
+		return ""
+	}
+	target := strings.TrimSpace(content[i+1:])
+	if len(target) >= 3 && strings.EqualFold(target[:3], "url") {
+		if rest := strings.TrimSpace(target[3:]); strings.HasPrefix(rest, "=") {
+			target = strings.TrimSpace(rest[1:])
+		}
+	}
+	return strings.Trim(target, `'"`)
+}
+
+// Links without those at the positions given, which are in increasing order
+func dropLinks(links []Link, positions []int) []Link {
+	var res []Link
+	for i, link := range links {
+		if len(positions) > 0 && positions[0] == i {
+			positions = positions[1:]
+			continue
+		}
+		res = append(res, link)
+	}
+	return res
+}
+
+// Decode body to UTF-8 from the charset in the Content-Type, BOM or <meta>,
+// falling back to UTF-8 when none is declared
+func decodeCharset(body io.Reader, contentType string) io.Reader {
+	buffered := bufio.NewReaderSize(body, 1024)
+	head, _ := buffered.Peek(1024)
+	enc, name, certain := charset.DetermineEncoding(head, contentType)
+	// windows-1252 is also what's guessed when nothing is declared
+	if !certain && name == "windows-1252" && !bytes.Contains(bytes.ToLower(head), []byte("charset")) {
+		return buffered
+	}
+	if name != "utf-8" {
+		Log.Debugf("Decoding %s page", name)
+	}
+	return enc.NewDecoder().Reader(buffered)
+}
+
+// Create link, relative hrefs are resolved against base if given.
+// Without text the anchor's aria-label, then title, is used
+func NewLink(tag html.Token, text string, depth int, base *url.URL) Link {
+	link := Link{Text: normalizeText(text), Depth: depth, Element: atom.A.String()}
+	var label, title string
+	for _, attr := range tag.Attr {
+		switch attr.Key {
+		case atom.Href.String():
+			link.Url = resolveUrl(base, strings.TrimSpace(attr.Val))
+		case "aria-label":
+			label = normalizeText(attr.Val)
+		case atom.Title.String():
+			title = normalizeText(attr.Val)
+		}
+	}
+	link.Nofollow = relSet(tag)["nofollow"]
+	link.Scheme = leafScheme(link.Url)
+	if len(link.Text) == 0 {
+		link.Text = label
+	}
+	if len(link.Text) == 0 {
+		link.Text = title
+	}
+	return link
+}
+
+// Create link from an asset tag's src or href, false if it has none
+// or is a <link> that isn't a stylesheet. Text is the alt or title,
+// else the element name
+func NewAsset(tag html.Token, depth int, base *url.URL) (Link, bool) {
+	link := Link{Text: tag.Data, Depth: depth, Element: tag.Data}
+	var label string
+	for _, attr := range tag.Attr {
+		switch attr.Key {
+		case assetAttrs[tag.DataAtom].String():
+			link.Url = resolveUrl(base, strings.TrimSpace(attr.Val))
+		case atom.Alt.String(), atom.Title.String():
+			if len(label) == 0 {
+				label = normalizeText(attr.Val)
+			}
+		}
+	}
+	if tag.DataAtom == atom.Link && !relSet(tag)["stylesheet"] {
+		return link, false
+	}
+	if len(label) > 0 {
+		link.Text = label
+	}
+	link.Scheme = leafScheme(link.Url)
+	return link, link.Valid()
+}
+
+// Text with runs of whitespace collapsed to one space and trimmed
+func normalizeText(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// Text cut to at most max runes, 0 for no limit
+func truncateText(text string, max int) string {
+	if max <= 0 || utf8.RuneCountInString(text) <= max {
+		return text
+	}
+	return string([]rune(text)[:max])
+}
+
+// Space separated tokens of a tag's rel attribute, lowercased
+func relSet(tag html.Token) map[string]bool {
+	rel := make(map[string]bool)
+	for _, attr := range tag.Attr {
+		if attr.Key == atom.Rel.String() {
+			for _, value := range strings.Fields(strings.ToLower(attr.Val)) {
+				rel[value] = true
+			}
+		}
+	}
+	return rel
+}
+
+// Resolve href against base, returns "" for fragment-only hrefs (same page)
+// and hrefs that cannot be parsed, so the link is rejected by Valid()
+func resolveUrl(base *url.URL, href string) string {
+	if strings.HasPrefix(href, "#") {
+		return ""
+	}
+	ref, err := url.Parse(href)
+	if err != nil {
+		Log.Debugf("Bad href %q: %s", href, err)
+		return ""
+	}
+	if base == nil {
+		return ref.String()
+	}
+	return base.ResolveReference(ref).String()
+}
@@ -0,0 +1,54 @@
+package crawler
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// Response of the page at rawUrl with the html body
+func htmlResponse(t *testing.T, rawUrl string, body string) *http.Response {
+	t.Helper()
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"text/html; charset=utf-8"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    &http.Request{URL: u},
+	}
+}
+
+func TestExtractLinksMalformedHtml(t *testing.T) {
+	body := `<html><body>
+		</a> stray end tag
+		<div <p>>< broken <<tags</div>
+		<a href="/first">first
+		<a href="/second">second</a>
+		</a></a>
+		<p>text with invalid utf-8: ` + "\xff\xfe" + `</p>
+		<table><tr><a href="/in-table">in table</td></table>
+		<a href="/after">after</a>
+		<a href="/unclosed">unclosed`
+	links := ExtractLinks(htmlResponse(t, "https://example.com/", body), 1)
+
+	want := []struct{ url, text string }{
+		{"https://example.com/first", "first"},
+		{"https://example.com/second", "second"},
+		{"https://example.com/in-table", "in table"},
+		{"https://example.com/after", "after"},
+		{"https://example.com/unclosed", "unclosed"},
+	}
+	if len(links) != len(want) {
+		t.Fatalf("got %d links, want %d: %v", len(links), len(want), links)
+	}
+	for i, link := range links {
+		if link.Url != want[i].url || link.Text != want[i].text {
+			t.Errorf("link %d: got %q (%q), want %q (%q)", i, link.Url, link.Text, want[i].url, want[i].text)
+		}
+	}
+}
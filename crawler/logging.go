@@ -0,0 +1,86 @@
+package crawler
+
+import (
+	"fmt"
+	"os"
+
+	log "github.com/llimllib/loglevel"
+)
+
+// What a log line is about, zero values for none
+type LogFields struct {
+	Url    string
+	Depth  *int // nil for none, seeds are at 0
+	Status int
+}
+
+// Gets each log line with its level (debug, info, warn, error or fatal) and fields
+// instead of loglevel, e.g. to write them as json. Called from the crawl's goroutines,
+// concurrently. Set it before crawling, nil for loglevel's text output
+var LogHandler func(level string, msg string, fields LogFields)
+
+// Writes log lines with the fields they are about, through LogHandler if set, else loglevel
+type Logger struct {
+	fields LogFields
+}
+
+// Logger of lines about no url in particular
+var Log Logger
+
+// Logger of lines about a url
+func LogUrl(url string) Logger {
+	return Logger{LogFields{Url: url}}
+}
+
+// Logger of lines about a url at a depth
+func LogLink(url string, depth int) Logger {
+	return Logger{LogFields{Url: url, Depth: &depth}}
+}
+
+// The logger with the status of a response added
+func (self Logger) Status(status int) Logger {
+	self.fields.Status = status
+	return self
+}
+
+func (self Logger) Debugf(format string, args ...interface{}) {
+	if LogHandler == nil {
+		log.Debugf(format, args...)
+		return
+	}
+	LogHandler("debug", fmt.Sprintf(format, args...), self.fields)
+}
+
+func (self Logger) Infof(format string, args ...interface{}) {
+	if LogHandler == nil {
+		log.Infof(format, args...)
+		return
+	}
+	LogHandler("info", fmt.Sprintf(format, args...), self.fields)
+}
+
+func (self Logger) Warnf(format string, args ...interface{}) {
+	if LogHandler == nil {
+		log.Warnf(format, args...)
+		return
+	}
+	LogHandler("warn", fmt.Sprintf(format, args...), self.fields)
+}
+
+func (self Logger) Errorf(format string, args ...interface{}) {
+	if LogHandler == nil {
+		log.Errorf(format, args...)
+		return
+	}
+	LogHandler("error", fmt.Sprintf(format, args...), self.fields)
+}
+
+// Log then exit with status 1
+func (self Logger) Fatalf(format string, args ...interface{}) {
+	if LogHandler == nil {
+		log.Fatalf(format, args...)
+		return
+	}
+	LogHandler("fatal", fmt.Sprintf(format, args...), self.fields)
+	os.Exit(1)
+}
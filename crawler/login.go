@@ -0,0 +1,66 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Submit a login form before crawling: POST the fields url encoded to loginUrl,
+// with the crawl's client settings and headers, following its redirects. Returns
+// the cookies it set, for Options.Cookies, a failed status is an error. Fields
+// tied to the session like CSRF tokens can't be given this way, use a cookie file
+func Login(ctx context.Context, loginUrl string, fields url.Values, opts Options) ([]*http.Cookie, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	opts = opts.withDefaults()
+	u, err := url.Parse(loginUrl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return nil, fmt.Errorf("login url must be an http or https url, got %q", loginUrl)
+	}
+	fetch := newFetcher(opts)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, loginUrl, strings.NewReader(fields.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	fetch.setHeader(req, http.Header{"Content-Type": {"application/x-www-form-urlencoded"}})
+	LogUrl(loginUrl).Infof("Logging in: %s", loginUrl)
+	resp, err := fetch.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, opts.MaxBodySize))
+	resp.Body.Close()
+	if !fetch.ok(resp.StatusCode) {
+		return nil, fmt.Errorf("%s: %s", loginUrl, resp.Status)
+	}
+
+	// what the jar sends back to the login url and where it redirected,
+	// as cookies for those hosts only
+	var cookies []*http.Cookie
+	seen := make(map[string]bool)
+	for _, page := range []*url.URL{u, resp.Request.URL} {
+		for _, cookie := range fetch.client.Jar.Cookies(page) {
+			key := page.Hostname() + " " + cookie.Name
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			cookies = append(cookies, &http.Cookie{
+				Name:   cookie.Name,
+				Value:  cookie.Value,
+				Domain: page.Hostname(),
+				Path:   "/",
+				Secure: page.Scheme == "https",
+			})
+		}
+	}
+	if len(cookies) == 0 {
+		LogUrl(loginUrl).Warnf("Login to %s set no cookies, crawling without a session", loginUrl)
+	}
+	return cookies, nil
+}
@@ -0,0 +1,70 @@
+package crawler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Characters kept in saved file names, others become _
+var unsafeNameChars = regexp.MustCompile(`[^\w.~-]`)
+
+// Page bodies saved under a directory mirroring the site, host/path of each url
+type bodySaver struct {
+	dir string
+	all bool // also save non-html bodies
+}
+
+// File a url's body is saved to. Html pages without an .html or .htm name get one,
+// directories an index.html, so /docs and /docs/a can both be saved, and a query
+// adds a hash of it, so ?page=2 isn't saved over ?page=1
+func (self *bodySaver) Path(rawUrl string, html bool) (string, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", err
+	}
+	// cleaned as an absolute path, so .. can't leave the host's directory
+	clean := path.Clean("/" + u.Path)
+	var parts []string
+	for _, part := range strings.Split(clean, "/") {
+		if len(part) > 0 {
+			parts = append(parts, unsafeNameChars.ReplaceAllString(part, "_"))
+		}
+	}
+	name := "index.html"
+	if len(parts) > 0 && !strings.HasSuffix(u.Path, "/") {
+		name = parts[len(parts)-1]
+		parts = parts[:len(parts)-1]
+	}
+	ext := strings.ToLower(path.Ext(name))
+	if len(u.RawQuery) > 0 {
+		sum := sha256.Sum256([]byte(u.RawQuery))
+		name = strings.TrimSuffix(name, path.Ext(name)) + "_" + hex.EncodeToString(sum[:4]) + path.Ext(name)
+	}
+	if html && ext != ".html" && ext != ".htm" {
+		name += ".html"
+	}
+	host := unsafeNameChars.ReplaceAllString(u.Host, "_")
+	return filepath.Join(append(append([]string{self.dir, host}, parts...), name)...), nil
+}
+
+// Write the body of the url, skipped if it's not html unless all bodies are saved
+func (self *bodySaver) Save(rawUrl string, contentType string, data []byte) error {
+	html := isHtml(contentType)
+	if !html && !self.all {
+		return nil
+	}
+	path, err := self.Path(rawUrl, html)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,438 @@
+package crawler
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func writeToFile(path string, text string) error {
+	f, err := CreateFile(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, text); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Create or truncate the file, gzip-compressed if path ends in .gz,
+// the compressed stream is only complete once closed
+func CreateFile(path string) (io.WriteCloser, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return f, nil
+	}
+	return &gzipFile{Writer: gzip.NewWriter(f), file: f}, nil
+}
+
+type gzipFile struct {
+	*gzip.Writer
+	file *os.File
+}
+
+func (self *gzipFile) Close() error {
+	err := self.Writer.Close()
+	if closeErr := self.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Default csv columns, in order
+var csvHeader = []string{"text", "url", "depth", "external", "title", "final_url", "status", "content_type", "error", "element", "nofollow", "last_modified", "canonical", "meta_refresh", "scheme", "mixed_content", "redirects", "disallowed"}
+
+// Value of each csv column for a link, parent isn't in the default columns
+var csvColumns = map[string]func(Link) string{
+	"text":      func(link Link) string { return strings.Replace(link.Text, "\n", " ", -1) },
+	"url":       func(link Link) string { return link.Url },
+	"depth":     func(link Link) string { return strconv.Itoa(link.Depth) },
+	"external":  func(link Link) string { return strconv.FormatBool(link.External) },
+	"title":     func(link Link) string { return link.Title },
+	"final_url": func(link Link) string { return link.FinalUrl },
+	"status": func(link Link) string {
+		if link.Status == 0 {
+			return "" // never fetched
+		}
+		return strconv.Itoa(link.Status)
+	},
+	"content_type":  func(link Link) string { return link.ContentType },
+	"error":         func(link Link) string { return link.Err },
+	"element":       func(link Link) string { return link.Element },
+	"nofollow":      func(link Link) string { return strconv.FormatBool(link.Nofollow) },
+	"last_modified": func(link Link) string { return link.LastModified },
+	"canonical":     func(link Link) string { return link.Canonical },
+	"meta_refresh":  func(link Link) string { return link.MetaRefresh },
+	"scheme":        func(link Link) string { return link.Scheme },
+	"mixed_content": func(link Link) string { return strconv.FormatBool(link.MixedContent) },
+	"redirects":     func(link Link) string { return strings.Join(link.Redirects, " -> ") },
+	"disallowed":    func(link Link) string { return strconv.FormatBool(link.Disallowed) },
+	"parent":        func(link Link) string { return link.Parent },
+}
+
+// Whether name is a column WriteLinksToCsv can write
+func IsCsvColumn(name string) bool {
+	_, ok := csvColumns[name]
+	return ok
+}
+
+func csvRow(link Link, columns []string) []string {
+	row := make([]string, len(columns))
+	for i, name := range columns {
+		row[i] = csvColumns[name](link)
+	}
+	return row
+}
+
+// Links as csv with the columns given in order, else the default ones
+func WriteLinksToCsv(outputPath string, links []Link, columns ...string) error {
+	if len(columns) == 0 {
+		columns = csvHeader
+	}
+	err := os.RemoveAll(outputPath)
+	if err != nil {
+		return err
+	}
+	f, err := CreateFile(outputPath)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f) // quotes fields per RFC 4180
+	w.Write(columns)
+	for _, link := range links {
+		w.Write(csvRow(link, columns))
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func WriteLinksToJson(outputPath string, links []Link) error {
+	err := os.RemoveAll(outputPath)
+	if err != nil {
+		return err
+	}
+	if links == nil {
+		links = []Link{} // empty array rather than null
+	}
+	data, err := json.MarshalIndent(links, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeToFile(outputPath, string(data)+"\n")
+}
+
+// One json object per line, for jq or streaming ingest
+func WriteLinksToNdjson(outputPath string, links []Link) error {
+	err := os.RemoveAll(outputPath)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	for _, link := range links {
+		data, err := json.Marshal(link)
+		if err != nil {
+			return err
+		}
+		b.Write(data)
+		b.WriteByte('\n')
+	}
+	return writeToFile(outputPath, b.String())
+}
+
+// Writes links one at a time as a crawl finds them, in the same csv, json or ndjson
+// format as WriteLinksToCsv/WriteLinksToJson/WriteLinksToNdjson, csv with the columns
+// given. Each link is flushed to the writer, so results so far survive a crash
+type LinkWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	format  string
+	csv     *csv.Writer
+	columns []string
+	count   int
+}
+
+func NewLinkWriter(w io.Writer, format string, columns ...string) (*LinkWriter, error) {
+	if len(columns) == 0 {
+		columns = csvHeader
+	}
+	self := &LinkWriter{w: w, format: format, columns: columns}
+	var err error
+	switch format {
+	case "json":
+		_, err = io.WriteString(w, "[")
+	case "ndjson":
+	default:
+		self.csv = csv.NewWriter(w)
+		self.csv.Write(columns)
+		self.csv.Flush()
+		err = self.csv.Error()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return self, nil
+}
+
+func (self *LinkWriter) Write(link Link) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.count++
+	if self.csv != nil {
+		self.csv.Write(csvRow(link, self.columns))
+		self.csv.Flush()
+		return self.csv.Error()
+	}
+	if self.format == "ndjson" {
+		data, err := json.Marshal(link)
+		if err != nil {
+			return err
+		}
+		_, err = self.w.Write(append(data, '\n'))
+		return err
+	}
+	data, err := json.MarshalIndent(link, "  ", "  ")
+	if err != nil {
+		return err
+	}
+	separator := ",\n  "
+	if self.count == 1 {
+		separator = "\n  "
+	}
+	_, err = io.WriteString(self.w, separator+string(data))
+	return err
+}
+
+// Close the json array, the underlying writer is left open
+func (self *LinkWriter) Close() error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if self.csv != nil || self.format == "ndjson" {
+		return nil
+	}
+	end := "\n]\n"
+	if self.count == 0 {
+		end = "]\n"
+	}
+	_, err := io.WriteString(self.w, end)
+	return err
+}
+
+// Failed urls as csv, for broken link checks
+func WriteFailuresToCsv(outputPath string, failures []Failure) error {
+	err := os.RemoveAll(outputPath)
+	if err != nil {
+		return err
+	}
+	f, err := CreateFile(outputPath)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"url", "depth", "status", "error"})
+	for _, failure := range failures {
+		status := ""
+		if failure.Status != 0 {
+			status = strconv.Itoa(failure.Status)
+		}
+		w.Write([]string{failure.Url, strconv.Itoa(failure.Depth), status, failure.Err})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Groups of pages linking to each other in cycles as csv, a row per page
+// with its group's number and size, for finding crawl traps
+func WriteCyclesToCsv(outputPath string, cycles [][]string) error {
+	err := os.RemoveAll(outputPath)
+	if err != nil {
+		return err
+	}
+	f, err := CreateFile(outputPath)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"cycle", "pages", "url"})
+	for i, cycle := range cycles {
+		for _, url := range cycle {
+			w.Write([]string{strconv.Itoa(i + 1), strconv.Itoa(len(cycle)), url})
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Graphviz digraph of the crawl, nodes are urls
+func WriteGraphToDot(outputPath string, result Result) error {
+	err := os.RemoveAll(outputPath)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	b.WriteString("digraph crawl {\n")
+	for _, link := range result.Links {
+		fmt.Fprintf(&b, "\t%s;\n", strconv.Quote(link.Url))
+	}
+	for _, edge := range result.Edges {
+		fmt.Fprintf(&b, "\t%s -> %s;\n", strconv.Quote(edge.From), strconv.Quote(edge.To))
+	}
+	b.WriteString("}\n")
+	return writeToFile(outputPath, b.String())
+}
+
+// Links as an indented tree, each under the page it was first found on and its
+// text, depth and url like Link.String(). A url also linked from other pages is
+// listed there again with where it's expanded, so cycles end
+func WriteTree(w io.Writer, result Result) error {
+	links := make(map[string]Link)     // map url to its first link
+	found := make(map[string][]string) // map page url to urls of the links found on it, in order
+	onPage := make(map[string]bool)    // page url + " " + url of a link already under it
+	for _, link := range result.Links {
+		if _, ok := links[link.Url]; !ok {
+			links[link.Url] = link
+		}
+	}
+	for _, edge := range result.Edges {
+		if key := edge.From + " " + edge.To; !onPage[key] {
+			onPage[key] = true
+			found[edge.From] = append(found[edge.From], edge.To)
+		}
+	}
+
+	b := bufio.NewWriter(w)
+	expanded := make(map[string]bool)
+	var write func(link Link, level int)
+	write = func(link Link, level int) {
+		expanded[link.Url] = true
+		fmt.Fprintf(b, "%s%s (%d) - %s\n", strings.Repeat("\t", level), link.Text, link.Depth, link.Url)
+		for _, url := range found[link.Url] {
+			child, ok := links[url]
+			switch {
+			case !ok:
+				continue // dropped from the results, e.g. filtered
+			case expanded[url] || child.Parent != link.Url:
+				where := ", a seed"
+				if len(child.Parent) > 0 {
+					where = ", under " + child.Parent
+				}
+				fmt.Fprintf(b, "%s%s (%d) - %s%s\n", strings.Repeat("\t", level+1), child.Text, child.Depth, url, where)
+			default:
+				write(child, level+1)
+			}
+		}
+	}
+	for _, link := range result.Links {
+		if len(link.Parent) == 0 && !expanded[link.Url] {
+			write(link, 0)
+		}
+	}
+	return b.Flush()
+}
+
+// Limits per sitemap file, from sitemaps.org
+const (
+	sitemapMaxUrls  = 50000
+	sitemapMaxBytes = 50 << 20
+)
+
+const sitemapHeader = xml.Header + `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">` + "\n"
+const sitemapFooter = "</urlset>\n"
+
+// Sitemap of the fetched, non-external urls, redirects listed by their final url.
+// Past 50,000 urls or 50MB the urls are split into outputPath-1.xml, -2.xml, ...
+// and outputPath is a sitemap index of them, located under baseUrl. A .gz path
+// is compressed, split files too as -1.xml.gz...
+func WriteSitemap(outputPath string, baseUrl string, links []Link) error {
+	var files [][]string // <url> entries per file
+	var entries []string
+	size := len(sitemapHeader) + len(sitemapFooter)
+	seen := make(map[string]bool)
+	for _, link := range links {
+		loc := link.Url
+		if len(link.FinalUrl) > 0 {
+			loc = link.FinalUrl
+		}
+		if link.External || link.Status == 0 || len(link.Err) > 0 || seen[loc] {
+			continue
+		}
+		seen[loc] = true
+		entry := sitemapEntry("url", loc, link.LastModified)
+		if len(entries) == sitemapMaxUrls || size+len(entry) > sitemapMaxBytes {
+			files = append(files, entries)
+			entries = nil
+			size = len(sitemapHeader) + len(sitemapFooter)
+		}
+		entries = append(entries, entry)
+		size += len(entry)
+	}
+	files = append(files, entries)
+
+	if len(files) == 1 {
+		return writeSitemapFile(outputPath, sitemapHeader, files[0], sitemapFooter)
+	}
+	var index []string
+	base, gz := strings.CutSuffix(outputPath, ".gz")
+	ext := filepath.Ext(base)
+	if gz {
+		ext += ".gz"
+	}
+	for i, entries := range files {
+		path := fmt.Sprintf("%s-%d%s", strings.TrimSuffix(base, filepath.Ext(base)), i+1, ext)
+		if err := writeSitemapFile(path, sitemapHeader, entries, sitemapFooter); err != nil {
+			return err
+		}
+		loc := strings.TrimSuffix(baseUrl, "/") + "/" + filepath.Base(path)
+		index = append(index, sitemapEntry("sitemap", loc, ""))
+	}
+	header := xml.Header + `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">` + "\n"
+	return writeSitemapFile(outputPath, header, index, "</sitemapindex>\n")
+}
+
+// <url> or <sitemap> element, lastModified is a Last-Modified header
+func sitemapEntry(tag string, loc string, lastModified string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "  <%s>\n    <loc>", tag)
+	xml.EscapeText(&b, []byte(loc))
+	b.WriteString("</loc>\n")
+	if date, err := http.ParseTime(lastModified); err == nil {
+		fmt.Fprintf(&b, "    <lastmod>%s</lastmod>\n", date.UTC().Format(time.RFC3339))
+	}
+	fmt.Fprintf(&b, "  </%s>\n", tag)
+	return b.String()
+}
+
+func writeSitemapFile(outputPath string, header string, entries []string, footer string) error {
+	err := os.RemoveAll(outputPath)
+	if err != nil {
+		return err
+	}
+	return writeToFile(outputPath, header+strings.Join(entries, "")+footer)
+}
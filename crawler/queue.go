@@ -0,0 +1,44 @@
+package crawler
+
+// Links waiting to be fetched, e.g. backed by disk or Redis for crawls too big
+// for memory. Only called from the crawl's own goroutine, so implementations
+// needn't be safe for concurrent use
+type Queue interface {
+	Push(link Link)
+	Pop() (link Link, ok bool) // next link to fetch, false if there's none
+	Len() int
+}
+
+// In-memory queue of the strategy, first in first out for "bfs",
+// last in first out for "dfs"
+func NewMemoryQueue(strategy string) Queue {
+	return &memoryQueue{lifo: strategy == "dfs"}
+}
+
+type memoryQueue struct {
+	links []Link
+	lifo  bool
+}
+
+func (self *memoryQueue) Push(link Link) {
+	self.links = append(self.links, link)
+}
+
+func (self *memoryQueue) Pop() (Link, bool) {
+	if len(self.links) == 0 {
+		return Link{}, false
+	}
+	var link Link
+	if self.lifo {
+		link = self.links[len(self.links)-1]
+		self.links = self.links[:len(self.links)-1]
+	} else {
+		link = self.links[0]
+		self.links = self.links[1:]
+	}
+	return link, true
+}
+
+func (self *memoryQueue) Len() int {
+	return len(self.links)
+}
@@ -0,0 +1,108 @@
+package crawler
+
+import (
+	"html/template"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Browsable page of a crawl's results. html/template escapes link text and titles,
+// and replaces unsafe hrefs like javascript: urls
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Crawl report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+section { border-top: 1px solid #ccc; padding: 0.5em 0; }
+.meta { color: #666; }
+.error { color: #b00; }
+</style>
+</head>
+<body>
+<h1>Crawl report</h1>
+<p class="meta">{{.Stats.Discovered}} urls found, {{.Stats.Fetched}} fetched in {{.Elapsed}}</p>
+<ul>
+{{- range .Pages}}
+<li><a href="#{{.Id}}">{{.Link.Url}}</a></li>
+{{- end}}
+</ul>
+{{- range .Pages}}
+<section id="{{.Id}}">
+<h2><a href="{{.Link.Url}}">{{if .Link.Title}}{{.Link.Title}}{{else}}{{.Link.Url}}{{end}}</a></h2>
+<p class="meta">{{.Link.Url}}, depth {{.Link.Depth}}{{if .Link.Status}}, status {{.Link.Status}}{{end}}{{if .Link.FinalUrl}}, redirected to {{.Link.FinalUrl}}{{end}}</p>
+{{- if .Link.Err}}
+<p class="error">{{.Link.Err}}</p>
+{{- end}}
+{{- if .Links}}
+<ol>
+{{- range .Links}}
+<li><a href="{{.Link.Url}}">{{.Link.Text}}</a>{{if .Id}} <a href="#{{.Id}}">(report)</a>{{end}}</li>
+{{- end}}
+</ol>
+{{- end}}
+</section>
+{{- end}}
+</body>
+</html>
+`))
+
+// A fetched page of the report, Id is its anchor
+type reportPage struct {
+	Id    string
+	Link  Link
+	Links []reportLink
+}
+
+// Link found on a page, Id is set if the report has a section for it
+type reportLink struct {
+	Id   string
+	Link Link
+}
+
+// Single self-contained html file listing each fetched page with its title,
+// status, depth and the links found on it
+func WriteReportToHtml(outputPath string, result Result) error {
+	err := os.RemoveAll(outputPath)
+	if err != nil {
+		return err
+	}
+	links := make(map[string]Link) // map url to its first link
+	ids := make(map[string]string) // map url of a fetched page to its anchor
+	var pages []reportPage
+	for _, link := range result.Links {
+		if _, ok := links[link.Url]; !ok {
+			links[link.Url] = link
+		}
+		if _, ok := ids[link.Url]; ok || (link.Status == 0 && len(link.Err) == 0) {
+			continue // listed already, or never fetched
+		}
+		ids[link.Url] = "page-" + strconv.Itoa(len(pages))
+		pages = append(pages, reportPage{Id: ids[link.Url], Link: link})
+	}
+	found := make(map[string][]reportLink) // map page url to links found on it
+	for _, edge := range result.Edges {
+		link, ok := links[edge.To]
+		if !ok {
+			link = Link{Url: edge.To, Text: edge.To}
+		}
+		found[edge.From] = append(found[edge.From], reportLink{Id: ids[edge.To], Link: link})
+	}
+	for i := range pages {
+		pages[i].Links = found[pages[i].Link.Url]
+	}
+
+	var b strings.Builder
+	err = reportTemplate.Execute(&b, struct {
+		Stats   Stats
+		Elapsed time.Duration
+		Pages   []reportPage
+	}{result.Stats, result.Stats.Elapsed.Round(time.Millisecond), pages})
+	if err != nil {
+		return err
+	}
+	return writeToFile(outputPath, b.String())
+}
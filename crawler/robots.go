@@ -0,0 +1,199 @@
+package crawler
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Path pattern from an Allow/Disallow line
+type robotsRule struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+// Patterns match path prefixes, with `*` wildcards and `$` end anchor
+func newRobotsRule(pattern string) robotsRule {
+	expr := strings.Replace(regexp.QuoteMeta(strings.TrimSuffix(pattern, "$")), `\*`, ".*", -1)
+	if strings.HasSuffix(pattern, "$") {
+		expr += "$"
+	}
+	return robotsRule{pattern: pattern, re: regexp.MustCompile("^" + expr)}
+}
+
+// Allow/Disallow rules and Crawl-delay from the robots.txt group for our agent
+type robotsRules struct {
+	allow    []robotsRule
+	disallow []robotsRule
+	delay    time.Duration // min interval between requests asked for, 0 for none
+}
+
+// Longest matching pattern wins, Allow wins ties. No rules allows everything
+func (self *robotsRules) Allowed(path string) bool {
+	longest := func(rules []robotsRule) int {
+		n := -1
+		for _, rule := range rules {
+			if len(rule.pattern) > n && rule.re.MatchString(path) {
+				n = len(rule.pattern)
+			}
+		}
+		return n
+	}
+	return longest(self.allow) >= longest(self.disallow)
+}
+
+// Parse robots.txt, keeping rules of the group naming agent, else the `*` group
+func parseRobots(body io.Reader, agent string) *robotsRules {
+	agent = strings.ToLower(agent)
+	groups := make(map[string]*robotsRules) // map agent to its rules
+	var current []*robotsRules
+	inAgents := false // consecutive User-agent lines share a group
+
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(parts[0]))
+		value := strings.TrimSpace(parts[1])
+
+		switch key {
+		case "user-agent":
+			if !inAgents {
+				current = nil
+			}
+			inAgents = true
+			name := strings.ToLower(value)
+			if groups[name] == nil {
+				groups[name] = &robotsRules{}
+			}
+			current = append(current, groups[name])
+		case "allow", "disallow":
+			inAgents = false
+			if len(value) == 0 {
+				continue // empty Disallow allows everything
+			}
+			for _, rules := range current {
+				if key == "allow" {
+					rules.allow = append(rules.allow, newRobotsRule(value))
+				} else {
+					rules.disallow = append(rules.disallow, newRobotsRule(value))
+				}
+			}
+		case "crawl-delay":
+			inAgents = false
+			seconds, err := strconv.ParseFloat(value, 64)
+			if err != nil || !(seconds > 0) {
+				continue
+			}
+			for _, rules := range current {
+				rules.delay = time.Duration(seconds * float64(time.Second))
+			}
+		default:
+			inAgents = false
+		}
+	}
+
+	// most specific agent name wins
+	match := ""
+	for name := range groups {
+		if name != "*" && len(name) > len(match) && strings.Contains(agent, name) {
+			match = name
+		}
+	}
+	if len(match) == 0 {
+		match = "*"
+	}
+	if rules, ok := groups[match]; ok {
+		return rules
+	}
+	return &robotsRules{}
+}
+
+type robotsEntry struct {
+	once  sync.Once
+	rules *robotsRules
+}
+
+// Parsed robots.txt per scheme+host, each fetched once per crawl
+type robotsCache struct {
+	mu      sync.Mutex
+	fetch   *fetcher
+	entries map[string]*robotsEntry
+}
+
+func newRobotsCache(fetch *fetcher) *robotsCache {
+	return &robotsCache{fetch: fetch, entries: make(map[string]*robotsEntry)}
+}
+
+func (self *robotsCache) Allowed(ctx context.Context, rawUrl string) bool {
+	u, err := url.Parse(rawUrl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return true // not fetched over http, nothing to check
+	}
+	path := u.EscapedPath()
+	if len(path) == 0 {
+		path = "/"
+	}
+	if len(u.RawQuery) > 0 {
+		path += "?" + u.RawQuery
+	}
+	return self.rules(ctx, u).Allowed(path)
+}
+
+// Crawl-delay robots.txt asks for on the url's host, 0 for none
+func (self *robotsCache) Delay(ctx context.Context, rawUrl string) time.Duration {
+	u, err := url.Parse(rawUrl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return 0
+	}
+	return self.rules(ctx, u).delay
+}
+
+// Rules of the url's robots.txt, fetched on first use
+func (self *robotsCache) rules(ctx context.Context, u *url.URL) *robotsRules {
+	key := u.Scheme + "://" + u.Host
+
+	self.mu.Lock()
+	entry, ok := self.entries[key]
+	if !ok {
+		entry = &robotsEntry{}
+		self.entries[key] = entry
+	}
+	self.mu.Unlock()
+
+	entry.once.Do(func() {
+		entry.rules = fetchRobots(ctx, self.fetch, key+"/robots.txt")
+	})
+	return entry.rules
+}
+
+// Missing or unreachable robots.txt allows everything
+func fetchRobots(ctx context.Context, fetch *fetcher, robotsUrl string) *robotsRules {
+	resp, err := fetch.getUrl(ctx, robotsUrl)
+	if err != nil {
+		return &robotsRules{}
+	}
+	defer resp.Body.Close()
+	return parseRobots(resp.Body, robotsAgent(fetch.userAgent))
+}
+
+// Product token of a User-agent header matched against robots.txt groups,
+// e.g. go-crawler for go-crawler/1.0
+func robotsAgent(userAgent string) string {
+	if i := strings.IndexAny(userAgent, "/ "); i >= 0 {
+		return userAgent[:i]
+	}
+	return userAgent
+}
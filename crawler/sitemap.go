@@ -0,0 +1,86 @@
+package crawler
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Sitemap indexes nested deeper than this are not followed
+const maxSitemapDepth = 5
+
+// <urlset> or <sitemapindex>, whichever the document is
+type sitemapDoc struct {
+	Urls     []sitemapLoc `xml:"url"`
+	Sitemaps []sitemapLoc `xml:"sitemap"`
+}
+
+type sitemapLoc struct {
+	Loc string `xml:"loc"`
+}
+
+// Page urls listed in a sitemap, following sitemap indexes to their child sitemaps.
+// Gzipped sitemaps (.xml.gz) are decompressed
+func SitemapUrls(ctx context.Context, sitemapUrl string, opts Options) ([]string, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	fetch := newFetcher(opts.withDefaults())
+	var urls []string
+	seen := make(map[string]bool) // sitemaps already read, in case an index lists itself
+	err := readSitemap(ctx, fetch, sitemapUrl, 0, seen, &urls)
+	return urls, err
+}
+
+func readSitemap(ctx context.Context, fetch *fetcher, sitemapUrl string, depth int, seen map[string]bool, urls *[]string) error {
+	if seen[sitemapUrl] {
+		return nil
+	}
+	seen[sitemapUrl] = true
+	if depth > maxSitemapDepth {
+		LogUrl(sitemapUrl).Warnf("Sitemap nested too deep, skipping: %s", sitemapUrl)
+		return nil
+	}
+
+	resp, err := fetch.getUrl(ctx, sitemapUrl)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// .xml.gz files are gzip content rather than a gzip Content-Encoding
+	var body io.Reader = bufio.NewReader(resp.Body)
+	if magic, _ := body.(*bufio.Reader).Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		reader, err := gzip.NewReader(body)
+		if err != nil {
+			return fmt.Errorf("sitemap %s: %s", sitemapUrl, err)
+		}
+		defer reader.Close()
+		body = reader
+	}
+
+	var doc sitemapDoc
+	if err := xml.NewDecoder(body).Decode(&doc); err != nil {
+		return fmt.Errorf("sitemap %s: %s", sitemapUrl, err)
+	}
+	for _, entry := range doc.Urls {
+		if loc := strings.TrimSpace(entry.Loc); len(loc) > 0 {
+			*urls = append(*urls, loc)
+		}
+	}
+	LogUrl(sitemapUrl).Infof("Sitemap %s: %d urls, %d sitemaps", sitemapUrl, len(doc.Urls), len(doc.Sitemaps))
+	for _, entry := range doc.Sitemaps {
+		loc := strings.TrimSpace(entry.Loc)
+		if len(loc) == 0 {
+			continue
+		}
+		// a broken child sitemap shouldn't lose the others
+		if err := readSitemap(ctx, fetch, loc, depth+1, seen, urls); err != nil {
+			Log.Warnf("Skipping child sitemap: %s", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package crawler
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"time"
+)
+
+// How often a crawl with Options.State saves its progress
+const stateInterval = 10 * time.Second
+
+// Progress of a crawl saved to Options.State, enough to pick it up again
+type crawlState struct {
+	Links    []Link      `json:"links"`
+	Edges    []Edge      `json:"edges"`
+	Failed   []Failure   `json:"failed"`
+	Visited  []string    `json:"visited"`  // normalized urls
+	Pages    []statePage `json:"pages"`    // fetched pages, without links
+	Frontier []Link      `json:"frontier"` // links to crawl that weren't fetched yet
+}
+
+// pageResult with exported fields
+type statePage struct {
+	Key          string   `json:"key"`
+	Url          string   `json:"url"`
+	FinalUrl     string   `json:"final_url,omitempty"`
+	Redirects    []string `json:"redirects,omitempty"`
+	Status       int      `json:"status,omitempty"`
+	ContentType  string   `json:"content_type,omitempty"`
+	LastModified string   `json:"last_modified,omitempty"`
+	Err          string   `json:"error,omitempty"`
+	Title        string   `json:"title,omitempty"`
+	Canonical    string   `json:"canonical,omitempty"`
+	MetaRefresh  string   `json:"meta_refresh,omitempty"`
+}
+
+func loadState(path string) (*crawlState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var state crawlState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// Write through a temp file, so a crash mid-save keeps the previous state
+func saveState(path string, state *crawlState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func statePages(pages map[string]pageResult) []statePage {
+	var res []statePage
+	for key, page := range pages {
+		res = append(res, statePage{
+			Key:          key,
+			Url:          page.url,
+			FinalUrl:     page.finalUrl,
+			Redirects:    page.redirects,
+			Status:       page.status,
+			ContentType:  page.contentType,
+			LastModified: page.lastModified,
+			Err:          page.err,
+			Title:        page.title,
+			Canonical:    page.canonical,
+			MetaRefresh:  page.metaRefresh,
+		})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Key < res[j].Key })
+	return res
+}
+
+func (self statePage) pageResult() pageResult {
+	return pageResult{
+		url:          self.Url,
+		finalUrl:     self.FinalUrl,
+		redirects:    self.Redirects,
+		status:       self.Status,
+		contentType:  self.ContentType,
+		lastModified: self.LastModified,
+		err:          self.Err,
+		title:        self.Title,
+		canonical:    self.Canonical,
+		metaRefresh:  self.MetaRefresh,
+	}
+}
+
+// Unfetched links shallowest first, then in the order they were found
+func stateFrontier(unfetched map[string]Link, index map[string]int) []Link {
+	var keys []string
+	for key := range unfetched {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := unfetched[keys[i]], unfetched[keys[j]]
+		if a.Depth != b.Depth {
+			return a.Depth < b.Depth
+		}
+		return index[keys[i]] < index[keys[j]]
+	})
+	var links []Link
+	for _, key := range keys {
+		links = append(links, unfetched[key])
+	}
+	return links
+}
@@ -0,0 +1,186 @@
+package crawler
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Normalized host used for same-domain comparison, port is dropped.
+// With subdomains the registrable domain is used (www.a.com -> a.com), ips are kept whole
+func hostKey(rawUrl string, subdomains bool) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return ""
+	}
+	host := canonicalHost(strings.TrimSuffix(u.Hostname(), "."))
+	if subdomains && net.ParseIP(host) == nil {
+		if domain, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+			return domain
+		}
+	}
+	return host
+}
+
+// Lowercase host, ipv6 literals in their shortest form so [2001:db8:0::1]
+// and [2001:DB8::1] are the same, keeping any %zone as is. Without brackets
+func canonicalHost(host string) string {
+	addr, zone, hasZone := strings.Cut(host, "%")
+	if ip := net.ParseIP(addr); ip != nil && strings.Contains(addr, ":") && ip.To4() == nil {
+		host = ip.String()
+		if hasZone {
+			host += "%" + zone
+		}
+		return host
+	}
+	return strings.ToLower(host)
+}
+
+var defaultPorts = map[string]string{"http": "80", "https": "443"}
+
+// Click ids and other tracking query params besides the utm_ prefixed ones
+var trackingParams = map[string]bool{
+	"fbclid":  true,
+	"gclid":   true,
+	"dclid":   true,
+	"msclkid": true,
+	"yclid":   true,
+	"igshid":  true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"_ga":     true,
+	"_hsenc":  true,
+}
+
+// Tracking query params are utm_ prefixed or known click ids
+func isTrackingParam(key string) bool {
+	key = strings.ToLower(key)
+	return strings.HasPrefix(key, "utm_") || trackingParams[key]
+}
+
+// Scheme of a url recorded but never fetched, e.g. mailto, tel, ftp or data,
+// "" for http, https and relative urls
+func leafScheme(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return ""
+	}
+	switch scheme := strings.ToLower(u.Scheme); scheme {
+	case "", "http", "https":
+		return ""
+	default:
+		return scheme
+	}
+}
+
+// Whether the url's path starts with prefix, an empty path is "/"
+func hasPathPrefix(rawUrl string, prefix string) bool {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return false
+	}
+	path := u.Path
+	if len(path) == 0 {
+		path = "/"
+	}
+	return strings.HasPrefix(path, prefix)
+}
+
+// Url without its #fragment, which names a part of the same resource
+func stripFragment(rawUrl string) string {
+	if i := strings.IndexByte(rawUrl, '#'); i >= 0 {
+		return rawUrl[:i]
+	}
+	return rawUrl
+}
+
+// Optional parts of normalizing a url
+type urlRules struct {
+	stripped      func(key string) bool // query params to drop, nil for none
+	trailingSlash string                // "add" or "remove" a trailing slash, "" to leave it
+	collapseWww   bool                  // www.example.com is example.com
+}
+
+// Host with the rules applied, www. dropped with collapseWww unless
+// that leaves a single label, like www.com
+func (self urlRules) host(host string) string {
+	if rest, ok := strings.CutPrefix(host, "www."); ok && self.collapseWww && strings.Contains(rest, ".") {
+		return rest
+	}
+	return host
+}
+
+// Canonical form of a url used as the visited key: lowercase scheme and host,
+// no default port, no fragment, "/" for an empty path, www. collapsed, the trailing slash
+// added or removed and without the stripped query params. Unparseable urls are returned as is
+func normalizeURL(rawUrl string, rules urlRules) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil || len(u.Host) == 0 {
+		return rawUrl
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	host := rules.host(canonicalHost(u.Hostname()))
+	port := u.Port()
+	if port == defaultPorts[u.Scheme] {
+		port = ""
+	}
+	if len(port) > 0 {
+		u.Host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		u.Host = "[" + host + "]" // ipv6 literal
+	} else {
+		u.Host = host
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+	if len(u.Path) == 0 {
+		u.Path = "/"
+	}
+	u.Path = withTrailingSlash(u.Path, rules.trailingSlash)
+	if len(u.RawPath) > 0 {
+		u.RawPath = withTrailingSlash(u.RawPath, rules.trailingSlash)
+	}
+	if rules.stripped != nil && len(u.RawQuery) > 0 {
+		query := u.Query()
+		for key := range query {
+			if rules.stripped(key) {
+				query.Del(key)
+			}
+		}
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// Path with a trailing slash added or removed, the root stays "/". Adding skips
+// file-like paths whose last segment has an extension, like /a.html
+func withTrailingSlash(path string, rule string) string {
+	switch {
+	case path == "/":
+		return path
+	case rule == "remove":
+		if trimmed := strings.TrimRight(path, "/"); len(trimmed) > 0 {
+			return trimmed
+		}
+		return "/"
+	case rule == "add" && !strings.HasSuffix(path, "/"):
+		if strings.Contains(path[strings.LastIndexByte(path, '/')+1:], ".") {
+			return path
+		}
+		return path + "/"
+	}
+	return path
+}
+
+// Dedup key of a fetched page, its canonical url if it has one, else where it was fetched from
+func canonicalKey(page pageResult, rules urlRules) string {
+	switch {
+	case len(page.canonical) > 0:
+		return normalizeURL(page.canonical, rules)
+	case len(page.finalUrl) > 0:
+		return normalizeURL(page.finalUrl, rules)
+	}
+	return normalizeURL(page.url, rules)
+}
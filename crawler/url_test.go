@@ -0,0 +1,96 @@
+package crawler
+
+import "testing"
+
+func TestWithTrailingSlash(t *testing.T) {
+	for _, test := range []struct {
+		path, rule, want string
+	}{
+		// root
+		{"/", "add", "/"},
+		{"/", "remove", "/"},
+		{"/", "", "/"},
+		{"//", "remove", "/"},
+		// file-like
+		{"/a.html", "add", "/a.html"},
+		{"/docs/report.pdf", "add", "/docs/report.pdf"},
+		{"/a.html", "remove", "/a.html"},
+		{"/a.html/", "remove", "/a.html"},
+		{"/v1.2/docs", "add", "/v1.2/docs/"},
+		// directory-like
+		{"/dir", "add", "/dir/"},
+		{"/dir/", "add", "/dir/"},
+		{"/dir", "remove", "/dir"},
+		{"/dir/", "remove", "/dir"},
+		{"/a/b//", "remove", "/a/b"},
+		{"/dir", "", "/dir"},
+		{"/dir/", "", "/dir/"},
+	} {
+		if got := withTrailingSlash(test.path, test.rule); got != test.want {
+			t.Errorf("withTrailingSlash(%q, %q) = %q, want %q", test.path, test.rule, got, test.want)
+		}
+	}
+}
+
+func TestNormalizeURLTrailingSlash(t *testing.T) {
+	for _, test := range []struct {
+		url, rule, want string
+	}{
+		{"http://x.com", "add", "http://x.com/"},
+		{"http://x.com", "remove", "http://x.com/"},
+		{"http://x.com/dir?q=1#top", "add", "http://x.com/dir/?q=1"},
+		{"http://x.com/dir/?q=1", "remove", "http://x.com/dir?q=1"},
+		{"http://x.com/a%2Fb/", "remove", "http://x.com/a%2Fb"},
+		{"http://x.com/index.html", "add", "http://x.com/index.html"},
+	} {
+		if got := normalizeURL(test.url, urlRules{trailingSlash: test.rule}); got != test.want {
+			t.Errorf("normalizeURL(%q) with %q = %q, want %q", test.url, test.rule, got, test.want)
+		}
+	}
+}
+
+func TestNormalizeURLIpv6(t *testing.T) {
+	for _, test := range []struct {
+		url, want string
+	}{
+		{"http://[::1]/path", "http://[::1]/path"},
+		{"http://[::1]:80/path", "http://[::1]/path"},
+		{"https://[::1]:443", "https://[::1]/"},
+		{"http://[::1]:8080/path", "http://[::1]:8080/path"},
+		{"http://[0:0::1]:8080/path#top", "http://[::1]:8080/path"},
+		{"http://[2001:DB8:0::1]/", "http://[2001:db8::1]/"},
+		{"http://[2001:db8::1]:8443/", "http://[2001:db8::1]:8443/"},
+		{"http://[fe80::1%25eth0]:8080/", "http://[fe80::1%25eth0]:8080/"},
+		{"http://127.0.0.1:80/", "http://127.0.0.1/"},
+	} {
+		if got := normalizeURL(test.url, urlRules{}); got != test.want {
+			t.Errorf("normalizeURL(%q) = %q, want %q", test.url, got, test.want)
+		}
+	}
+	// the same page however it's written
+	if normalizeURL("http://[2001:db8::1]:80/a", urlRules{}) != normalizeURL("http://[2001:DB8:0:0::1]/a", urlRules{}) {
+		t.Error("ipv6 forms of one url aren't deduped")
+	}
+	if normalizeURL("http://[::1]:8080/a", urlRules{}) == normalizeURL("http://[::1]:8081/a", urlRules{}) {
+		t.Error("ipv6 urls on other ports are deduped")
+	}
+}
+
+func TestHostKeyIpv6(t *testing.T) {
+	for _, test := range []struct {
+		url        string
+		subdomains bool
+		want       string
+	}{
+		{"http://[::1]/", false, "::1"},
+		{"http://[::1]:8080/", false, "::1"},
+		{"http://[0:0::1]:8080/", true, "::1"},
+		{"http://[2001:DB8::1]:8443/a", true, "2001:db8::1"},
+		{"http://127.0.0.1:8080/", true, "127.0.0.1"},
+		{"http://www.example.co.uk:8080/", true, "example.co.uk"},
+	} {
+		if got := hostKey(test.url, test.subdomains); got != test.want {
+			t.Errorf("hostKey(%q, %t) = %q, want %q", test.url, test.subdomains, got, test.want)
+		}
+	}
+}
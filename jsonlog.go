@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/leonmak/go-crawler/crawler"
+)
+
+// One log line of -log-format json
+type jsonLogEntry struct {
+	Time   string `json:"time"`
+	Level  string `json:"level"`
+	Msg    string `json:"msg"`
+	Url    string `json:"url,omitempty"`
+	Depth  *int   `json:"depth,omitempty"`
+	Status int    `json:"status,omitempty"`
+}
+
+// Writes each log line as a JSON object, set as crawler.LogHandler
+type jsonLogger struct {
+	mu    sync.Mutex // lines come from the crawl's goroutines
+	out   io.Writer
+	debug bool // also write debug lines, skipped like loglevel's info priority skips them
+}
+
+func (self *jsonLogger) Log(level string, msg string, fields crawler.LogFields) {
+	if level == "debug" && !self.debug {
+		return
+	}
+	line, err := json.Marshal(jsonLogEntry{
+		Time:   time.Now().Format(time.RFC3339Nano),
+		Level:  level,
+		Msg:    msg,
+		Url:    fields.Url,
+		Depth:  fields.Depth,
+		Status: fields.Status,
+	})
+	if err != nil {
+		return
+	}
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.out.Write(append(line, '\n'))
+}
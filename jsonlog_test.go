@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/leonmak/go-crawler/crawler"
+)
+
+// Log lines written while running f, as -log-format json writes them
+func jsonLogLines(t *testing.T, f func()) []jsonLogEntry {
+	t.Helper()
+	var out bytes.Buffer
+	crawler.LogHandler = (&jsonLogger{out: &out, debug: true}).Log
+	defer func() { crawler.LogHandler = nil }()
+	f()
+
+	var entries []jsonLogEntry
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var entry jsonLogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("not a json line %q: %s", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestJsonLogLevels(t *testing.T) {
+	entries := jsonLogLines(t, func() {
+		crawler.Log.Debugf("debug %d", 1)
+		crawler.Log.Infof("info %d", 2)
+		crawler.Log.Warnf("warn %d", 3)
+		crawler.Log.Errorf("error %d", 4)
+	})
+	want := []string{"debug", "info", "warn", "error"}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d lines, want %d: %v", len(entries), len(want), entries)
+	}
+	for i, entry := range entries {
+		if entry.Level != want[i] {
+			t.Errorf("%q: got level %q, want %q", entry.Msg, entry.Level, want[i])
+		}
+		if len(entry.Time) == 0 {
+			t.Errorf("%q: no time", entry.Msg)
+		}
+	}
+}
+
+func TestJsonLogSkipsDebug(t *testing.T) {
+	var out bytes.Buffer
+	logger := &jsonLogger{out: &out}
+	logger.Log("debug", "debug", crawler.LogFields{})
+	if out.Len() > 0 {
+		t.Errorf("got %q, want debug lines skipped", out.String())
+	}
+}
+
+func TestJsonLogFields(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`<html><a href="/missing">missing</a></html>`))
+	}))
+	defer server.Close()
+	missing := server.URL + "/missing"
+
+	entries := jsonLogLines(t, func() {
+		if _, err := crawler.Crawl([]string{server.URL + "/"}, crawler.Options{MaxDepth: 2, IgnoreRobots: true}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	var appended, failed *jsonLogEntry
+	for i, entry := range entries {
+		if strings.HasPrefix(entry.Msg, "Appended: "+missing) {
+			appended = &entries[i]
+		}
+		if strings.HasPrefix(entry.Msg, "Failed "+missing) {
+			failed = &entries[i]
+		}
+	}
+	if appended == nil {
+		t.Fatalf("no line for appending %s: %v", missing, entries)
+	}
+	if appended.Level != "info" || appended.Url != missing || appended.Depth == nil || *appended.Depth != 1 {
+		t.Errorf("appended: got %+v, want info with url %s and depth 1", *appended, missing)
+	}
+	if failed == nil {
+		t.Fatalf("no line for failing %s: %v", missing, entries)
+	}
+	if failed.Level != "warn" || failed.Url != missing || failed.Status != http.StatusNotFound {
+		t.Errorf("failed: got %+v, want warn with url %s and status 404", *failed, missing)
+	}
+}